@@ -0,0 +1,131 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// installModAtomic prepares m in a staging directory next to m.Dest()
+// and swaps it into place only after checkout succeeds.
+// m.Dest() is never modified if something fails.
+func (c Installer) installModAtomic(ctx context.Context, m *Mod) error {
+	dest := m.Dest()
+	staging := hiddenPath(dest, "staging")
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+
+	backup := ""
+	if exists(dest) {
+		var err error
+		if backup, err = c.dirtyAtomic(m); err != nil {
+			return err
+		}
+	}
+
+	err := c.prepare(ctx, m, staging)
+	if err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+
+	c.phase(m, "swap")
+	old := backup
+	if old == "" {
+		old = hiddenPath(dest, "old")
+		os.RemoveAll(old)
+	}
+	if err := swapDir(staging, dest, old); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+	if backup == "" {
+		return os.RemoveAll(old)
+	}
+	return nil
+}
+
+// prepare makes a working tree of m in staging. An existing module is
+// cloned locally so that only new objects are fetched.
+func (c Installer) prepare(ctx context.Context, m *Mod, staging string) error {
+	dest := m.Dest()
+	if !exists(dest) {
+		if err := c.clone(ctx, m, staging); err != nil {
+			return err
+		}
+		return c.checkout(ctx, m, staging, false)
+	}
+
+	c.phase(m, "clone")
+	if err := c.gitClone(ctx, dest, staging); err != nil {
+		return err
+	}
+	// a local clone doesn't have remote-tracking branches of dest
+	c.phase(m, "fetch")
+	abs, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	if err := c.gitFetchRefs(ctx, staging, abs, "+refs/remotes/origin/*:refs/remotes/origin/*"); err != nil {
+		return err
+	}
+	if err := c.fetch(ctx, m, staging); err != nil {
+		return err
+	}
+	return c.checkout(ctx, m, staging, false)
+}
+
+// dirtyAtomic applies the policy for local changes without modifying m.Dest().
+// It returns a backup path if the current module is kept after the swap.
+func (c Installer) dirtyAtomic(m *Mod) (string, error) {
+	st, err := localChanges(m.Dest())
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(st) == "" {
+		return "", nil
+	}
+	policy, err := dirtyPolicy(c.OnDirty, c.Force)
+	if err != nil {
+		return "", err
+	}
+	m.dirty = policy
+	switch policy {
+	case dirtyReset:
+		return "", nil
+	case dirtyBackup:
+		b := backupPath(m.Dest(), time.Now())
+		m.dirty += " to " + b
+		return b, nil
+	case dirtyStash:
+		return "", fmt.Errorf("%v cannot be used with atomic install", dirtyStash)
+	}
+	m.cmd = "status"
+	return "", errDirtyWorktree
+}
+
+// swapDir moves dest to old and staging to dest. dest is restored
+// if staging cannot be moved.
+func swapDir(staging, dest, old string) error {
+	if exists(dest) {
+		if err := os.Rename(dest, old); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(staging, dest); err != nil {
+		if exists(old) {
+			os.Rename(old, dest)
+		}
+		return err
+	}
+	return nil
+}
+
+// hiddenPath returns a path next to dest which isn't regarded as a module.
+func hiddenPath(dest, suffix string) string {
+	return filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+"."+suffix)
+}
@@ -0,0 +1,72 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallModAtomic(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	mdir := filepath.Join(dir, "modules")
+	os.Mkdir(mdir, 0755)
+
+	up := filepath.Join(dir, "upstream")
+	c := testInstaller(t, Installer{Atomic: true})
+	m := &Mod{name: "foo", moddir: mdir, opts: ModOpts{"git": up, "ref": "v0.1.0"}}
+	assert.Nil(t, c.installMod(ctx, m))
+	assert.Equal(t, gitSha1(up, "v0.1.0"), gitSha1(m.Dest(), "HEAD"))
+
+	m = &Mod{name: "foo", moddir: mdir, opts: ModOpts{"git": up, "ref": "develop"}}
+	assert.Nil(t, c.installMod(ctx, m))
+	assert.Equal(t, gitSha1(up, "develop"), gitSha1(m.Dest(), "HEAD"))
+
+	// the working module is kept if checkout fails
+	m = &Mod{name: "foo", moddir: mdir, opts: ModOpts{"git": up, "ref": "no-such-ref"}}
+	assert.NotNil(t, c.installMod(ctx, m))
+	assert.Equal(t, gitSha1(up, "develop"), gitSha1(m.Dest(), "HEAD"))
+	fs, _ := ioutil.ReadDir(mdir)
+	assert.Equal(t, 1, len(fs))
+
+	// local changes
+	ioutil.WriteFile(filepath.Join(m.Dest(), "untracked"), []byte("a"), 0644)
+	m = &Mod{name: "foo", moddir: mdir, opts: ModOpts{"git": up, "ref": "master"}}
+	assert.Equal(t, errDirtyWorktree, c.installMod(ctx, m))
+	assert.True(t, exists(filepath.Join(m.Dest(), "untracked")))
+
+	c.OnDirty = dirtyBackup
+	assert.Nil(t, c.installMod(ctx, m))
+	assert.Equal(t, gitSha1(up, "master"), gitSha1(m.Dest(), "HEAD"))
+	assert.False(t, exists(filepath.Join(m.Dest(), "untracked")))
+	bs, _ := filepath.Glob(filepath.Join(mdir, ".foo.backup-*", "untracked"))
+	assert.Equal(t, 1, len(bs))
+}
+
+func TestSwapDir(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lpg-test")
+	defer os.RemoveAll(dir)
+	p := func(s string) string { return filepath.Join(dir, s) }
+
+	os.Mkdir(p("dest"), 0755)
+	ioutil.WriteFile(p("dest/a"), []byte("old"), 0644)
+	os.Mkdir(p("staging"), 0755)
+	ioutil.WriteFile(p("staging/a"), []byte("new"), 0644)
+
+	assert.Nil(t, swapDir(p("staging"), p("dest"), p("old")))
+	b, _ := ioutil.ReadFile(p("dest/a"))
+	assert.Equal(t, "new", string(b))
+	b, _ = ioutil.ReadFile(p("old/a"))
+	assert.Equal(t, "old", string(b))
+	assert.False(t, exists(p("staging")))
+
+	// dest is restored
+	assert.NotNil(t, swapDir(p("missing"), p("dest"), p("old2")))
+	b, _ = ioutil.ReadFile(p("dest/a"))
+	assert.Equal(t, "new", string(b))
+}
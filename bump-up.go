@@ -5,11 +5,16 @@ import (
 	"log"
 )
 
-func bumpUp(a, b string, init string) {
+// bumpUp prints b bumping up mods selected by sel, and the others as they are.
+func bumpUp(a, b string, init string, sel Selector) {
 	am := parse(a)
 	bm := parse(b)
 
 	for _, n := range bm {
+		if !sel.Match(n) {
+			fmt.Println(n.Format())
+			continue
+		}
 		e, _ := bumpUpMod(n, am, init, a, gitDiff)
 		fmt.Println(e)
 	}
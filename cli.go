@@ -1,10 +1,12 @@
 package librarianpuppetgo
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"time"
 
 	"github.com/jawher/mow.cli"
 )
@@ -17,6 +19,7 @@ func CLIMain() {
 	var (
 		verbose = app.Bool(cli.BoolOpt{Name: "v verbose", EnvVar: "LP_VERBOSE", Desc: "Show logs verbosely"})
 		modpath = app.String(cli.StringOpt{Name: "module-path", Value: "modules", Desc: "Path to be for modules"})
+		cfgpath = app.String(cli.StringOpt{Name: "config", Value: ".librarian-puppet-go.json", EnvVar: "LP_CONFIG", Desc: "Path to a config file in JSON"})
 	)
 	app.Before = func() {
 		if *verbose {
@@ -32,24 +35,163 @@ func CLIMain() {
 		forceOpt    = cli.BoolOpt{Name: "force f", Desc: "checkout with --force"}
 		includesOpt = cli.StringOpt{Name: "includes-with-repository-name", Value: ".*", Desc: "Specify modules to be installed"}
 		timeoutOpt  = cli.IntOpt{Name: "timeout", Value: 60 * 3, Desc: "Timeout to clone or fetch by git"}
+		retryOpt    = cli.IntOpt{Name: "retry", Value: 2, EnvVar: "LP_RETRY", Desc: "Number of retries to clone, fetch or pull by git"}
+		retryWait   = cli.IntOpt{Name: "retry-wait", Value: 1, EnvVar: "LP_RETRY_WAIT", Desc: "Seconds to wait before the first retry. It's doubled for each retry"}
+		rewriteOpt  = cli.StringsOpt{Name: "rewrite", Desc: "Rewrite a git URL which starts with FROM to TO, given as FROM=TO"}
+		rewriteRe   = cli.StringsOpt{Name: "rewrite-regexp", Desc: "Rewrite a git URL which matches REGEXP to TO, given as REGEXP=TO"}
+		atomicOpt   = cli.BoolOpt{Name: "atomic", EnvVar: "LP_ATOMIC", Desc: "Prepare each module in a staging directory and swap it into place after checkout"}
+		submodOpt   = cli.BoolOpt{Name: "submodules", EnvVar: "LP_SUBMODULES", Desc: "Initialize and update git submodules recursively for all modules"}
+		lsRemoteOpt = cli.BoolOpt{Name: "ls-remote", EnvVar: "LP_LS_REMOTE", Desc: "Skip fetch of a branch if git ls-remote shows it's already at the head"}
+		purgeOpt    = cli.BoolOpt{Name: "purge", Desc: "Purge directories in the module path which no mod declares"}
+		trashOpt    = cli.StringOpt{Name: "trash", Desc: "Move purged directories into this directory instead of removing"}
+		keepOpt     = cli.StringsOpt{Name: "keep", Desc: "Glob of directory names never purged"}
+		reportOpt   = cli.StringOpt{Name: "report", EnvVar: "LP_REPORT", Desc: "Write a report of each module in json or junit"}
+		reportFile  = cli.StringOpt{Name: "report-file", EnvVar: "LP_REPORT_FILE", Desc: "Path to write a report, stdout if empty"}
+		hostLimit   = cli.StringsOpt{Name: "host-limit", EnvVar: "LP_HOST_LIMIT", Desc: "Limit git network operations at once for a host of git URLs, given as HOST=N. * for other hosts"}
+		rateOpt     = cli.IntOpt{Name: "rate-limit", EnvVar: "LP_RATE_LIMIT", Desc: "Limit git network operations started per second in all hosts. No limit if 0"}
+		summaryOpt  = cli.BoolOpt{Name: "summary", EnvVar: "LP_SUMMARY", Desc: "Print wall time, time of each git operation, slowest modules, bytes received and utilization of workers"}
+		traceOpt    = cli.StringOpt{Name: "trace", Desc: "Write every git command to this file in Chrome trace-event JSON"}
+		dryRunOpt   = cli.BoolOpt{Name: "dry-run", Desc: "Print a plan of what would be done without any change"}
+		planOpt     = cli.StringOpt{Name: "plan-file", Desc: "Write a plan in JSON with --dry-run"}
+		applyOpt    = cli.BoolOpt{Name: "apply", Desc: "FILE is a plan written by --plan-file, and modules are checked out at sha1 in it"}
+		waitOpt     = cli.IntOpt{Name: "wait", EnvVar: "LP_WAIT", Desc: "Seconds to wait for another process holding the lock of a directory modules are installed into"}
+		watchOpt    = cli.BoolOpt{Name: "watch", Desc: "Install again mods whose declarations change when the puppetfile or its includes are modified"}
+		metricsOpt  = cli.StringOpt{Name: "metrics-file", EnvVar: "LP_METRICS_FILE", Desc: "Write metrics in the Prometheus text format to this file, e.g. for the textfile collector of node_exporter"}
+		metricsAddr = cli.StringOpt{Name: "metrics-listen", EnvVar: "LP_METRICS_LISTEN", Desc: "Serve metrics on /metrics of this address with --watch"}
+		progressOpt = cli.BoolOpt{Name: "progress", Value: true, EnvVar: "LP_PROGRESS", Desc: "Show progress of each module, a line for each event if stdout is not a terminal"}
+		dirtyOpt    = cli.StringOpt{Name: "on-dirty", Value: "", EnvVar: "LP_ON_DIRTY",
+			Desc: `What to do for a module which has local changes before checkout.
+                 abort, stash, reset or backup. abort by default, reset with --force`}
+		strategyOpt = cli.StringOpt{Name: "branch-strategy", EnvVar: "LP_BRANCH_STRATEGY",
+			Desc: `How to update a module at a branch. pull merges origin/<branch>,
+                 reset moves it to origin/<branch>. reset by default if stdin is not a terminal`}
 	)
 	f := func(b bool) func(c *cli.Cmd) {
 		return func(c *cli.Cmd) {
 			file := c.String(fileArg)
+			names := c.Strings(cli.StringsArg{Name: "MODULES", Desc: "Globs of module names to be installed"})
+			sel := selectorOpts(c)
 			throttle := c.Int(throttleOpt)
 			force := c.Bool(forceOpt)
 			includes := c.String(includesOpt)
 			tout := c.Int(timeoutOpt)
-			c.Spec = "[OPTIONS] FILE"
+			submod := c.Bool(submodOpt)
+			retries := c.Int(retryOpt)
+			wait := c.Int(retryWait)
+			rewrites := c.Strings(rewriteOpt)
+			rewritesRe := c.Strings(rewriteRe)
+			dirty := c.String(dirtyOpt)
+			atomic := c.Bool(atomicOpt)
+			lsRemote := c.Bool(lsRemoteOpt)
+			strategy := c.String(strategyOpt)
+			purge := c.Bool(purgeOpt)
+			trash := c.String(trashOpt)
+			keep := c.Strings(keepOpt)
+			prog := c.Bool(progressOpt)
+			report := c.String(reportOpt)
+			reportPath := c.String(reportFile)
+			hostLimits := c.Strings(hostLimit)
+			rate := c.Int(rateOpt)
+			summary := c.Bool(summaryOpt)
+			trace := c.String(traceOpt)
+			dryRun := c.Bool(dryRunOpt)
+			planFile := c.String(planOpt)
+			apply := c.Bool(applyOpt)
+			lockWait := c.Int(waitOpt)
+			watch := c.Bool(watchOpt)
+			metricsFile := c.String(metricsOpt)
+			metricsListen := c.String(metricsAddr)
+			c.Spec = "[OPTIONS] FILE [MODULES...]"
 			c.Action = func() {
-				timeout = *tout
+				if err := checkReportFormat(*report); err != nil {
+					log.Fatalf("%v", err)
+				}
+				if *dryRun && *apply {
+					log.Fatalf("--dry-run cannot be used with --apply")
+				}
+				if *watch && (*apply || *report != "") {
+					log.Fatalf("--watch cannot be used with --apply nor --report")
+				}
+				if *metricsListen != "" && !*watch {
+					log.Fatalf("--metrics-listen needs --watch")
+				}
+				if *planFile != "" && !*dryRun {
+					log.Fatalf("--plan-file needs --dry-run")
+				}
+				cfg, err := loadConfig(*cfgpath)
+				if err != nil {
+					log.Fatalf("%v", err)
+				}
+				rws, err := loadRewrites(cfg, *rewrites, *rewritesRe)
+				if err != nil {
+					log.Fatalf("%v", err)
+				}
+				bs, err := branchStrategy(*strategy, isTerminal(os.Stdin))
+				if err != nil {
+					log.Fatalf("%v", err)
+				}
+				hls, err := parseHostLimits(*hostLimits)
+				if err != nil {
+					log.Fatalf("%v", err)
+				}
 				c := installCmd{
-					throttle:             *throttle,
-					forceCheckout:        *force,
-					onlyCheckout:         b,
+					Installer: Installer{
+						ModulePath:     modulePath,
+						Concurrency:    *throttle,
+						Timeout:        time.Duration(*tout) * time.Second,
+						Logger:         logger,
+						Force:          *force,
+						OnlyCheckout:   b,
+						Submodules:     *submod,
+						Retries:        *retries,
+						RetryWait:      time.Duration(*wait) * time.Second,
+						Rewrites:       rws,
+						OnDirty:        *dirty,
+						Atomic:         *atomic,
+						LsRemote:       *lsRemote,
+						BranchStrategy: bs,
+						HostLimits:     hls,
+						Rate:           float64(*rate),
+						PreInstall:     cfg.PreInstall,
+						PostInstall:    cfg.PostInstall,
+					},
 					includesWithRepoName: *includes,
+					selector:             sel(*names...),
+					dryRun:               *dryRun,
+					planFile:             *planFile,
+					apply:                *apply,
+					trace:                *trace,
+					wait:                 time.Duration(*lockWait) * time.Second,
+					watch:                *watch,
+					metricsFile:          *metricsFile,
+					metricsAddr:          *metricsListen,
+				}
+				if *metricsFile != "" || *metricsListen != "" {
+					c.Metrics = NewMetrics()
+				}
+				if *summary {
+					c.summary = os.Stderr
+				}
+				if *prog && (*report == "" || *reportPath != "") {
+					// logs on stderr would break the view
+					c.progress, c.tty = os.Stdout, isTerminal(os.Stdout) && !*verbose
+				}
+				if *purge {
+					c.purge = &purgeOpts{trash: *trash, keep: append(*keep, cfg.PurgeKeep...)}
+				}
+				results, err := c.Main(*file)
+				printResults(results)
+				if *report != "" && results != nil {
+					if err := writeReportFile(*reportPath, *report, results); err != nil {
+						log.Printf("[error] report: %v", err)
+					}
+				}
+				if err != nil {
+					log.Fatalf("%v", err)
+				}
+				if len(failedResults(results)) > 0 {
+					os.Exit(1)
 				}
-				c.Main(*file)
 			}
 		}
 	}
@@ -63,6 +205,209 @@ func CLIMain() {
 		"Checkout modules without network access",
 		f(true),
 	)
+	app.Command(
+		"deploy",
+		"Deploy puppet environments",
+		func(c *cli.Cmd) {
+			c.Command(
+				"environments",
+				"Deploy an environment for each branch of a control repository",
+				func(c *cli.Cmd) {
+					c.LongDesc = `Deploy an environment for each branch of a control repository.
+Each branch is checked out into <envdir>/<branch>, modules in its Puppetfile
+are installed into <envdir>/<branch>/modules, and environments whose branches
+are gone are removed. Characters other than [A-Za-z0-9_] in branch names are replaced with _.
+
+e.g) deploy environments --envdir /etc/puppetlabs/code/environments git@github.com:tmtk75/control.git
+     deploy environments --branch 'feature/*' git@github.com:tmtk75/control.git`
+					url := c.String(cli.StringArg{Name: "URL", Desc: "URL of a control repository"})
+					envdir := c.String(cli.StringOpt{Name: "envdir", Value: defaultEnvDir, EnvVar: "LP_ENVDIR", Desc: "Directory for environments"})
+					branches := c.String(cli.StringOpt{Name: "branch", EnvVar: "LP_BRANCH", Desc: "Glob of branches to be deployed. Environments of other branches are kept"})
+					throttle := c.Int(throttleOpt)
+					tout := c.Int(timeoutOpt)
+					submod := c.Bool(submodOpt)
+					retries := c.Int(retryOpt)
+					wait := c.Int(retryWait)
+					rewrites := c.Strings(rewriteOpt)
+					rewritesRe := c.Strings(rewriteRe)
+					dirty := c.String(dirtyOpt)
+					hostLimits := c.Strings(hostLimit)
+					rate := c.Int(rateOpt)
+					metricsFile := c.String(metricsOpt)
+					lockWait := c.Int(waitOpt)
+					c.Spec = "[OPTIONS] URL"
+					c.Action = func() {
+						cfg, err := loadConfig(*cfgpath)
+						if err != nil {
+							log.Fatalf("%v", err)
+						}
+						rws, err := loadRewrites(cfg, *rewrites, *rewritesRe)
+						if err != nil {
+							log.Fatalf("%v", err)
+						}
+						hls, err := parseHostLimits(*hostLimits)
+						if err != nil {
+							log.Fatalf("%v", err)
+						}
+						d := Deployer{
+							Installer: Installer{
+								Concurrency:    *throttle,
+								Timeout:        time.Duration(*tout) * time.Second,
+								Logger:         logger,
+								Submodules:     *submod,
+								Retries:        *retries,
+								RetryWait:      time.Duration(*wait) * time.Second,
+								Rewrites:       rws,
+								OnDirty:        *dirty,
+								BranchStrategy: branchReset,
+								HostLimits:     hls,
+								Rate:           float64(*rate),
+								PreInstall:     cfg.PreInstall,
+								PostInstall:    cfg.PostInstall,
+							},
+							URL:      *url,
+							EnvDir:   *envdir,
+							Branches: *branches,
+							Wait:     time.Duration(*lockWait) * time.Second,
+						}
+						if *metricsFile != "" {
+							d.Metrics = NewMetrics()
+						}
+						ctx, cancel := withSignals(context.Background())
+						defer cancel()
+						envs, _, err := d.Deploy(ctx)
+						printEnvResults(envs)
+						if d.Metrics != nil {
+							if err := d.Metrics.WriteFile(*metricsFile); err != nil {
+								log.Printf("[error] metrics: %v", err)
+							}
+						}
+						if err != nil {
+							log.Fatalf("%v", err)
+						}
+						for _, e := range envs {
+							if e.Failed() {
+								os.Exit(1)
+							}
+						}
+					}
+				},
+			)
+		},
+	)
+	app.Command(
+		"server",
+		"Serve webhooks to install modules when repositories are pushed",
+		func(c *cli.Cmd) {
+			c.LongDesc = `Serve webhooks of GitHub, GitLab and Bitbucket to install modules when repositories are pushed.
+A push is mapped to a puppetfile and a module path by "routes" of "server" in the config,
+and installs in a module path run one by one.
+
+  POST /webhook   receives a push
+  GET  /status    shows recent deploys and results of modules
+  GET  /metrics   shows metrics in the Prometheus text format
+
+e.g) server --listen :8080 --config /etc/librarian-puppet-go.json`
+			listen := c.String(cli.StringOpt{Name: "listen", Value: ":8080", EnvVar: "LP_LISTEN", Desc: "Address to listen"})
+			secret := c.String(cli.StringOpt{Name: "secret", EnvVar: "LP_WEBHOOK_SECRET", Desc: "Secret of webhooks, prior to one in the config"})
+			lockWait := c.Int(cli.IntOpt{Name: "wait", Value: 600, EnvVar: "LP_WAIT", Desc: "Seconds to wait for another process holding the lock of a module path"})
+			throttle := c.Int(throttleOpt)
+			tout := c.Int(timeoutOpt)
+			submod := c.Bool(submodOpt)
+			retries := c.Int(retryOpt)
+			wait := c.Int(retryWait)
+			rewrites := c.Strings(rewriteOpt)
+			rewritesRe := c.Strings(rewriteRe)
+			dirty := c.String(dirtyOpt)
+			hostLimits := c.Strings(hostLimit)
+			rate := c.Int(rateOpt)
+			c.Action = func() {
+				cfg, err := loadConfig(*cfgpath)
+				if err != nil {
+					log.Fatalf("%v", err)
+				}
+				rws, err := loadRewrites(cfg, *rewrites, *rewritesRe)
+				if err != nil {
+					log.Fatalf("%v", err)
+				}
+				hls, err := parseHostLimits(*hostLimits)
+				if err != nil {
+					log.Fatalf("%v", err)
+				}
+				if *secret != "" {
+					cfg.Server.Secret = *secret
+				}
+				ctx, cancel := withSignals(context.Background())
+				defer cancel()
+				s, err := newServer(ctx, Installer{
+					Concurrency:    *throttle,
+					Timeout:        time.Duration(*tout) * time.Second,
+					Logger:         logger,
+					Submodules:     *submod,
+					Retries:        *retries,
+					RetryWait:      time.Duration(*wait) * time.Second,
+					Rewrites:       rws,
+					OnDirty:        *dirty,
+					BranchStrategy: branchReset,
+					HostLimits:     hls,
+					Rate:           float64(*rate),
+					PreInstall:     cfg.PreInstall,
+					PostInstall:    cfg.PostInstall,
+					Metrics:        NewMetrics(),
+				}, cfg.Server, cfg.PurgeKeep, time.Duration(*lockWait)*time.Second)
+				if err != nil {
+					log.Fatalf("%v", err)
+				}
+				if err := s.serve(*listen); err != nil {
+					log.Fatalf("%v", err)
+				}
+			}
+		},
+	)
+	app.Command(
+		"purge",
+		"Purge directories in the module path which no mod declares",
+		func(c *cli.Cmd) {
+			c.LongDesc = `Purge directories in the module path which no mod in a puppetfile declares
+after merging includes. Hidden directories are never purged.
+
+e.g) purge --dry-run Puppetfile
+     purge --keep 'site_*' --trash /tmp/trash Puppetfile`
+			file := c.String(fileArg)
+			dryRun := c.Bool(cli.BoolOpt{Name: "n dry-run", Desc: "Only print directories to be purged"})
+			trash := c.String(trashOpt)
+			keep := c.Strings(keepOpt)
+			lockWait := c.Int(waitOpt)
+			c.Spec = "[OPTIONS] FILE"
+			c.Action = func() {
+				cfg, err := loadConfig(*cfgpath)
+				if err != nil {
+					log.Fatalf("%v", err)
+				}
+				opts := purgeOpts{dryRun: *dryRun, trash: *trash, keep: append(*keep, cfg.PurgeKeep...)}
+				if err := purgeFile(*file, modulePath, opts, time.Duration(*lockWait)*time.Second); err != nil {
+					log.Fatalf("%v", err)
+				}
+			}
+		},
+	)
+	app.Command(
+		"verify",
+		"Verify modules are checked out as a puppetfile declares",
+		func(c *cli.Cmd) {
+			c.LongDesc = `Verify modules are checked out as a puppetfile declares.
+HEAD of each module is compared with :ref, origin/<branch> for a branch,
+and submodules are reported if they are out of sync.
+
+e.g) verify --submodules Puppetfile`
+			file := c.String(fileArg)
+			submod := c.Bool(submodOpt)
+			c.Spec = "[OPTIONS] FILE"
+			c.Action = func() {
+				Verify(*file, *submod)
+			}
+		},
+	)
 	app.Command(
 		"format",
 		"Format a puppetfile",
@@ -93,9 +438,10 @@ e.g) diff Puppetfile.staging Puppetfile.development
 			b := c.String(cli.StringArg{Name: "DST", Desc: "Destination puppetfile"})
 			d := c.Strings(cli.StringsArg{Name: "DIRS", Desc: "Directories to be compared"})
 			m := c.String(cli.StringOpt{Name: "mode", Value: STAT, Desc: fmt.Sprintf("Specify diff mode. %v, %v and %v", STAT, FULL, SUMMARY)})
+			sel := selectorOpts(c)
 			c.Spec = "[OPTIONS] SRC DST [DIRS...]"
 			c.Action = func() {
-				Diff(*a, *b, *d, *m)
+				Diff(*a, *b, *d, *m, sel())
 			}
 		},
 	)
@@ -106,9 +452,10 @@ e.g) diff Puppetfile.staging Puppetfile.development
 			a := c.String(cli.StringArg{Name: "SRC", Desc: "Source puppetfile"})
 			b := c.String(cli.StringArg{Name: "DST", Desc: "Destination puppetfile"})
 			remoteName := c.String(cli.StringOpt{Name: "remote-name", Value: "origin", Desc: "Remote name"})
-			c.Spec = "SRC DST"
+			sel := selectorOpts(c)
+			c.Spec = "[OPTIONS] SRC DST"
 			c.Action = func() {
-				PrintGitPushCmds(*remoteName, *a, *b)
+				PrintGitPushCmds(*remoteName, *a, *b, sel())
 			}
 		},
 	)
@@ -122,9 +469,10 @@ e.g) diff Puppetfile.staging Puppetfile.development
 			a := c.String(cli.StringArg{Name: "SRC", Desc: "Source puppetfile"})
 			b := c.String(cli.StringArg{Name: "DST", Desc: "Destination puppetfile"})
 			relBranch := c.String(cli.StringOpt{Name: "release-branch", Value: "release/0.1", Desc: "Release branch name used first"})
-			c.Spec = "SRC DST"
+			sel := selectorOpts(c)
+			c.Spec = "[OPTIONS] SRC DST"
 			c.Action = func() {
-				bumpUp(*a, *b, *relBranch)
+				bumpUp(*a, *b, *relBranch, sel())
 			}
 		},
 	)
@@ -163,6 +511,7 @@ Output doesn't have "v" prefix if it has "v".
 			prefix := c.String(cli.StringOpt{Name: "prefix p", Value: "", Desc: "Prefix template"})
 			suffix := c.String(cli.StringOpt{Name: "suffix s", Value: "", Desc: "Suffix template"})
 			body := c.String(cli.StringOpt{Name: "body b", Value: "{{.Value}}", Desc: "Body template"})
+			sel := selectorOpts(c)
 			c.LongDesc = `Exec a command you want for each module.
 
 You can use template notation in arguments and option parameters.
@@ -193,7 +542,9 @@ Examples
 `
 			c.Spec = "[OPTIONS] FILE ARGS..."
 			c.Action = func() {
-				NewGit().Each(*src, *args, eachOpts{
+				g := NewGit()
+				g.Selector = sel()
+				g.Each(*src, *args, eachOpts{
 					prefix: *prefix,
 					body:   *body,
 					suffix: *suffix,
@@ -204,4 +555,36 @@ Examples
 	app.Run(os.Args)
 }
 
-var logger = log.New(ioutil.Discard, "", log.LstdFlags)
+// Used by commands. Installer and ParsePuppetfile don't depend on them.
+var (
+	modulePath = "modules"
+	logger     = log.New(ioutil.Discard, "", log.LstdFlags)
+)
+
+// selectorOpts adds options to select modules to a command, and returns
+// a function to make a selector with more globs of names.
+func selectorOpts(c *cli.Cmd) func(names ...string) Selector {
+	globs := c.Strings(cli.StringsOpt{Name: "m module", Desc: "Glob of module names to be selected"})
+	tags := c.Strings(cli.StringsOpt{Name: "tag", Desc: "Select modules which have this tag in :tags"})
+	exclude := c.Strings(cli.StringsOpt{Name: "exclude", Desc: "Glob of module names never selected"})
+	return func(names ...string) Selector {
+		s := Selector{Names: append(append([]string{}, *globs...), names...), Tags: *tags, Exclude: *exclude}
+		if err := s.Check(); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return s
+	}
+}
+
+// loadRewrites puts rules given in command line prior to ones in config.
+func loadRewrites(cfg Config, prefixes, regexps []string) ([]Rewrite, error) {
+	a, err := parseRewrites(prefixes, false)
+	if err != nil {
+		return nil, err
+	}
+	b, err := parseRewrites(regexps, true)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(a, b...), cfg.Rewrites...), nil
+}
@@ -0,0 +1,50 @@
+package librarianpuppetgo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// Config is read from a JSON file given by --config.
+//
+//	{
+//	  "rewrites": [
+//	    {"prefix": "https://github.com/", "replace": "https://git.example.com/github/"},
+//	    {"regexp": "^git@github.com:(.*)$", "replace": "ssh://git@git.example.com/github/$1"}
+//	  ],
+//	  "purge_keep": ["site_*"],
+//	  "pre_install": ["test -w ."],
+//	  "post_install": ["rm -rf spec/fixtures"],
+//	  "server": {
+//	    "secret": "...",
+//	    "routes": [
+//	      {"repository": "tmtk75/*", "branch": "master", "puppetfile": "/etc/puppet/Puppetfile", "module_path": "/etc/puppet/modules"}
+//	    ]
+//	  }
+//	}
+type Config struct {
+	Rewrites    []Rewrite    `json:"rewrites"`
+	PurgeKeep   []string     `json:"purge_keep"`
+	PreInstall  []string     `json:"pre_install"`
+	PostInstall []string     `json:"post_install"`
+	Server      ServerConfig `json:"server"`
+}
+
+// loadConfig returns an empty config if path is empty or missing.
+func loadConfig(path string) (Config, error) {
+	var c Config
+	if path == "" {
+		return c, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		logger.Printf("config: %v is missing", path)
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
@@ -0,0 +1,29 @@
+package librarianpuppetgo
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	c, err := loadConfig("")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(c.Rewrites))
+
+	c, err = loadConfig("files/no-such-config.json")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(c.Rewrites))
+
+	f, err := ioutil.TempFile("", "lpg-config")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString(`{"rewrites": [{"prefix": "https://github.com/", "replace": "https://mirror/"}]}`)
+	f.Close()
+
+	c, err = loadConfig(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, []Rewrite{{Prefix: "https://github.com/", Replace: "https://mirror/"}}, c.Rewrites)
+}
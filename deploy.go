@@ -0,0 +1,279 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Deployer deploys an environment for each branch of a control repository,
+// which has a Puppetfile for modules of the environment.
+type Deployer struct {
+	Installer               // installs control repository and modules, ModulePath is ignored
+	URL       string        // control repository
+	EnvDir    string        // "environments" if empty
+	Branches  string        // glob of branch names to be deployed, all if empty
+	Wait      time.Duration // waits for locks of EnvDir and directories of modules
+}
+
+// EnvResult is a result of an environment.
+type EnvResult struct {
+	Name    string   // directory name in EnvDir
+	Branch  string   // branch of the control repository
+	Control Result   // result of the control repository
+	Results []Result // results of modules, nil if the control repository failed
+	Err     error    // error to read the Puppetfile
+}
+
+// Failed tells whether the environment or one of its modules failed.
+func (e EnvResult) Failed() bool {
+	return e.Control.Err != nil || e.Err != nil || len(failedResults(e.Results)) > 0
+}
+
+const (
+	defaultEnvDir = "environments"
+	envModuleDir  = "modules"
+)
+
+var invalidEnvChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// envName returns a directory name for a branch, which is a valid
+// environment name of puppet.
+func envName(branch string) string {
+	return invalidEnvChars.ReplaceAllString(branch, "_")
+}
+
+// Deploy checks out each branch into EnvDir/<name>, installs modules
+// in its Puppetfile into EnvDir/<name>/modules, and removes environments
+// whose branches are gone. It returns results in the order of names and
+// removed directories. An error is returned if branches can't be listed.
+// EnvDir and directories of modules are locked until it returns.
+func (d Deployer) Deploy(ctx context.Context) ([]EnvResult, []string, error) {
+	c, err := d.setup()
+	if err != nil {
+		return nil, nil, err
+	}
+	d.Installer = c
+	if d.EnvDir == "" {
+		d.EnvDir = defaultEnvDir
+	}
+	if _, err := path.Match(d.Branches, ""); err != nil {
+		return nil, nil, fmt.Errorf("bad pattern of branches: %v", d.Branches)
+	}
+	unlock, err := lockDirs(ctx, []string{d.EnvDir}, d.Wait)
+	if err != nil {
+		return nil, nil, err
+	}
+	unlocks := []func(){unlock}
+	defer func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}()
+
+	branches, err := d.branches(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	envs := make([]Mod, 0, len(branches))
+	names := map[string]string{}
+	for _, b := range branches {
+		n := envName(b)
+		if o, ok := names[n]; ok {
+			return nil, nil, fmt.Errorf("branches %v and %v are both deployed as %v", o, b, n)
+		}
+		names[n] = b
+		envs = append(envs, Mod{name: n, opts: ModOpts{"git": d.URL, "ref": b}})
+	}
+
+	// hooks are for modules, and atomic install would drop modules in it
+	ctl := d.Installer
+	ctl.ModulePath, ctl.PreInstall, ctl.PostInstall = d.EnvDir, nil, nil
+	ctl.Atomic, ctl.BranchStrategy = false, branchReset
+	ctls, err := ctl.Install(ctx, envs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results := make([]EnvResult, len(ctls))
+	for i, r := range ctls {
+		e := EnvResult{Name: r.Mod.name, Branch: r.Mod.Ref(), Control: r}
+		if r.Err == nil {
+			var unlock func()
+			e.Results, unlock, e.Err = d.deployEnv(ctx, r.Mod.Dest())
+			if unlock != nil {
+				unlocks = append(unlocks, unlock)
+			}
+		}
+		results[i] = e
+	}
+	if ctx.Err() != nil {
+		return results, nil, nil
+	}
+
+	removed, err := d.stale(names)
+	if err != nil {
+		return results, nil, err
+	}
+	return results, removed, purgeDirs(removed, purgeOpts{})
+}
+
+// branches returns names of branches in the control repository which match the pattern.
+func (d Deployer) branches(ctx context.Context) ([]string, error) {
+	url := d.rewriter.rewrite(d.URL)
+	var refs map[string]string
+	err := d.limiter.do(ctx, url, func() error {
+		var err error
+		refs, err = d.gitLsRemoteURL(ctx, url, "refs/heads/*")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	bs := make([]string, 0, len(refs))
+	for r := range refs {
+		b := strings.TrimPrefix(r, "refs/heads/")
+		if ok, _ := path.Match(d.Branches, b); d.Branches == "" || ok {
+			bs = append(bs, b)
+		}
+	}
+	sort.Strings(bs)
+	return bs, nil
+}
+
+// deployEnv installs modules in the Puppetfile of an environment at dir,
+// and returns a function to release locks of directories of the modules.
+func (d Deployer) deployEnv(ctx context.Context, dir string) ([]Result, func(), error) {
+	c := d.Installer
+	c.ModulePath = filepath.Join(dir, envModuleDir)
+	mods := []Mod{}
+	f, err := os.Open(filepath.Join(dir, "Puppetfile"))
+	switch {
+	case os.IsNotExist(err):
+		d.logger.Printf("no Puppetfile in %v", dir)
+	case err != nil:
+		return nil, nil, err
+	default:
+		defer f.Close()
+		pf, err := ParsePuppetfile(f, ParseOptions{Dir: dir, Logger: d.Logger})
+		if err != nil {
+			return nil, nil, err
+		}
+		mods = pf.Mods
+	}
+	if err := excludeModules(dir, c.ModulePath, mods); err != nil {
+		return nil, nil, err
+	}
+	unlock, err := lockDirs(ctx, modDirs(c.ModulePath, mods, nil), d.Wait)
+	if err != nil {
+		return nil, nil, err
+	}
+	rs, err := c.Install(ctx, mods)
+	return rs, unlock, err
+}
+
+// excludeModules keeps modules out of git status of an environment
+// so that it's never dirty with them. Directories for modules, or
+// each module with :install_path, are added to .git/info/exclude
+// with lock files in them.
+func excludeModules(dir, modpath string, mods []Mod) error {
+	ls := []string{"/" + envModuleDir + "/", lockFile}
+	for _, m := range mods {
+		m.moddir = modpath
+		p := m.dir()
+		if m.installPath != "" {
+			p = m.Dest()
+		}
+		if r, err := filepath.Rel(dir, p); err == nil && r != "." && !strings.HasPrefix(r, "..") {
+			ls = append(ls, "/"+filepath.ToSlash(r)+"/")
+		}
+	}
+
+	p := filepath.Join(dir, ".git", "info", "exclude")
+	b, err := ioutil.ReadFile(p)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	es := map[string]bool{}
+	for _, e := range strings.Split(string(b), "\n") {
+		es[e] = true
+	}
+	s := string(b)
+	for _, l := range ls {
+		if es[l] {
+			continue
+		}
+		es[l] = true
+		if s != "" && !strings.HasSuffix(s, "\n") {
+			s += "\n"
+		}
+		s += l + "\n"
+	}
+	if s == string(b) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, []byte(s), 0644)
+}
+
+// stale returns environments in EnvDir which aren't deployed now
+// but whose branches match the pattern. Directories which are not
+// at a branch are left as they are.
+func (d Deployer) stale(names map[string]string) ([]string, error) {
+	fs, err := ioutil.ReadDir(d.EnvDir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ds := make([]string, 0)
+	for _, f := range fs {
+		n := f.Name()
+		if !f.IsDir() || strings.HasPrefix(n, ".") {
+			continue
+		}
+		if _, ok := names[n]; ok {
+			continue
+		}
+		dir := filepath.Join(d.EnvDir, n)
+		if !exists(filepath.Join(dir, ".git")) {
+			continue
+		}
+		b := gitSymbolicRef(dir)
+		if b == "" {
+			continue
+		}
+		if ok, _ := path.Match(d.Branches, b); d.Branches == "" || ok {
+			ds = append(ds, dir)
+		}
+	}
+	return ds, nil
+}
+
+// printEnvResults prints each environment and failed modules in it.
+func printEnvResults(envs []EnvResult) {
+	for _, e := range envs {
+		r := e.Control
+		switch {
+		case r.Err != nil:
+			log.Printf("[%v] environment %v\t%v\t%v\t%v\n", r.Action, e.Name, e.Branch, r.Op, r.Err)
+		case e.Err != nil:
+			log.Printf("[failed] environment %v\t%v\t%v\n", e.Name, e.Branch, e.Err)
+		default:
+			fmt.Printf("%v\t%v\t%v\t%v\n", e.Name, e.Branch, r.Action, short(r.NewSha1))
+		}
+		printResults(e.Results)
+	}
+}
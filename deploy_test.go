@@ -0,0 +1,90 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "production", envName("production"))
+	assert.Equal(t, "feature_x_1", envName("feature/x-1"))
+}
+
+func TestDeploy(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	up := filepath.Join(dir, "upstream")
+	ctl := filepath.Join(dir, "control")
+	for _, c := range []string{
+		"git init -q control",
+		"cd control && git checkout -q -b production",
+		"cd control && echo \"mod 'foo', :git => '" + up + "', :ref => 'v0.1.0'\" > Puppetfile && git add Puppetfile && git commit -qm a",
+		"cd control && git checkout -q -b feature/x && echo \"mod 'foo', :git => '" + up + "', :ref => 'develop'\" > Puppetfile && git commit -qam b",
+		"cd control && git checkout -q -b old",
+	} {
+		cmd := exec.Command("sh", "-c", c)
+		cmd.Dir = dir
+		b, err := cmd.CombinedOutput()
+		assert.Nil(t, err, "%v: %s", c, b)
+	}
+
+	envdir := filepath.Join(dir, "environments")
+	d := Deployer{URL: ctl, EnvDir: envdir}
+	envs, removed, err := d.Deploy(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, removed)
+	assert.Equal(t, 3, len(envs))
+	assert.Equal(t, "feature_x", envs[0].Name)
+	assert.Equal(t, "feature/x", envs[0].Branch)
+	for _, e := range envs {
+		assert.False(t, e.Failed(), "%v", e.Name)
+		assert.Equal(t, 1, len(e.Results))
+	}
+	assert.Equal(t, gitSha1(up, "develop"), gitHead(filepath.Join(envdir, "feature_x", "modules", "foo")))
+	assert.Equal(t, gitSha1(up, "v0.1.0"), gitHead(filepath.Join(envdir, "production", "modules", "foo")))
+
+	cmd := exec.Command("sh", "-c", "git checkout -q production && git branch -qD old")
+	cmd.Dir = ctl
+	assert.Nil(t, cmd.Run())
+
+	// environments out of the pattern are kept
+	d.Branches = "prod*"
+	envs, removed, err = d.Deploy(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, removed)
+	assert.Equal(t, 1, len(envs))
+	assert.Equal(t, ActionUnchanged, envs[0].Control.Action)
+	assert.True(t, exists(filepath.Join(envdir, "old")))
+
+	d.Branches = ""
+	envs, removed, err = d.Deploy(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(envdir, "old")}, removed)
+	assert.Equal(t, 2, len(envs))
+	assert.False(t, exists(filepath.Join(envdir, "old")))
+	assert.True(t, exists(filepath.Join(envdir, "production", "modules", "foo")))
+	st, err := gitStatus(filepath.Join(envdir, "production"))
+	assert.Nil(t, err)
+	assert.Equal(t, "", st)
+
+	// another deploy holds locks of the environments and the modules
+	l, err := lockDir(ctx, envdir, 0)
+	assert.Nil(t, err)
+	_, _, err = d.Deploy(ctx)
+	assert.IsType(t, &LockedError{}, err)
+	l.unlock()
+
+	l, err = lockDir(ctx, filepath.Join(envdir, "production", "modules"), 0)
+	assert.Nil(t, err)
+	envs, _, err = d.Deploy(ctx)
+	assert.Nil(t, err)
+	assert.False(t, envs[0].Failed())
+	assert.IsType(t, &LockedError{}, envs[1].Err)
+	l.unlock()
+}
@@ -42,12 +42,12 @@ const (
 	SUMMARY = "SUMMARY"
 )
 
-func Diff(a, b string, dirs []string, mode string) {
+func Diff(a, b string, dirs []string, mode string, sel Selector) {
 	w := new(tabwriter.Writer)
 	w.Init(os.Stdout, 0, 8, 0, '\t', 0)
 	mode = strings.ToUpper(mode)
 
-	diff(a, b, func(oldm, newm Mod, oldref, newref string) {
+	diff(a, b, sel, func(oldm, newm Mod, oldref, newref string) {
 		args := []string{"--no-pager", "diff", "-w"}
 		if mode == STAT {
 			args = append(args, "--stat")
@@ -98,14 +98,17 @@ func parse(f string) []Mod {
 	if err != nil {
 		log.Fatalln(err)
 	}
+	for i := range mods {
+		mods[i].moddir = modulePath
+	}
 	return mods
 }
 
 type DiffFunc func(oldm, newm Mod, oldref, newref string)
 
-func diff(oldfile, newfile string, f DiffFunc) {
+func diff(oldfile, newfile string, sel Selector, f DiffFunc) {
 	oldmods := parse(oldfile)
-	newmods := parse(newfile)
+	newmods := sel.Select(parse(newfile))
 
 	for _, newm := range newmods {
 		oldm, err := findModIn(oldmods, newm)
@@ -0,0 +1,77 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Policies for local changes in a module before checkout.
+const (
+	dirtyAbort  = "abort"  // fail the module
+	dirtyStash  = "stash"  // stash and re-apply after checkout
+	dirtyReset  = "reset"  // discard them
+	dirtyBackup = "backup" // move the module to a backup directory and clone again
+	dirtyForce  = "force"  // leave them to checkout --force, which keeps untracked files
+)
+
+var errDirtyWorktree = errors.New("local changes in worktree")
+
+// dirtyPolicy returns a policy for a given name. If no name is given,
+// force is used for --force, otherwise abort.
+func dirtyPolicy(s string, force bool) (string, error) {
+	switch s {
+	case dirtyAbort, dirtyStash, dirtyReset, dirtyBackup:
+		return s, nil
+	case "":
+		if force {
+			return dirtyForce, nil
+		}
+		return dirtyAbort, nil
+	}
+	return "", fmt.Errorf("unknown policy for local changes: %v", s)
+}
+
+// handleDirty applies the policy if m has local changes.
+// It returns true if they are stashed. Changes made by hooks are not local ones.
+func (c Installer) handleDirty(ctx context.Context, m *Mod) (bool, error) {
+	st, err := localChanges(m.Dest())
+	if err != nil {
+		return false, err
+	}
+	if strings.TrimSpace(st) == "" {
+		return false, c.discardHookChanges(ctx, m)
+	}
+	policy, err := dirtyPolicy(c.OnDirty, c.Force)
+	if err != nil {
+		return false, err
+	}
+	m.dirty = policy
+	c.logger.Printf("local changes in %v: %v\n%v", m.Dest(), policy, st)
+
+	switch policy {
+	case dirtyForce:
+		return false, nil
+	case dirtyStash:
+		c.phase(m, "stash")
+		return true, c.gitStash(ctx, m.Dest())
+	case dirtyReset:
+		c.phase(m, "reset")
+		return false, c.gitResetHard(ctx, m.Dest())
+	case dirtyBackup:
+		c.phase(m, "backup")
+		b := backupPath(m.Dest(), time.Now())
+		m.dirty += " to " + b
+		return false, os.Rename(m.Dest(), b)
+	}
+	m.cmd = "status"
+	return false, errDirtyWorktree
+}
+
+// backupPath returns a hidden path next to dest.
+func backupPath(dest string, t time.Time) string {
+	return hiddenPath(dest, "backup-"+t.Format("20060102150405"))
+}
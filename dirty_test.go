@@ -0,0 +1,102 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirtyPolicy(t *testing.T) {
+	p, err := dirtyPolicy("", false)
+	assert.Nil(t, err)
+	assert.Equal(t, dirtyAbort, p)
+
+	p, err = dirtyPolicy("", true)
+	assert.Nil(t, err)
+	assert.Equal(t, dirtyForce, p)
+
+	p, err = dirtyPolicy(dirtyStash, true)
+	assert.Nil(t, err)
+	assert.Equal(t, dirtyStash, p)
+
+	_, err = dirtyPolicy("keep", false)
+	assert.NotNil(t, err)
+}
+
+func TestHandleDirty(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	mdir := dir
+	dest := filepath.Join(dir, "foo")
+	m := &Mod{name: "foo", moddir: mdir, opts: ModOpts{}}
+	assert.Nil(t, testRunner.gitClone(ctx, filepath.Join(dir, "upstream"), dest))
+	clone := func() {
+		os.RemoveAll(dest)
+		assert.Nil(t, testRunner.gitClone(ctx, filepath.Join(dir, "upstream"), dest))
+		ioutil.WriteFile(filepath.Join(dest, "README"), []byte("changed"), 0644)
+		ioutil.WriteFile(filepath.Join(dest, "untracked"), []byte("new"), 0644)
+	}
+
+	// clean
+	stashed, err := testInstaller(t, Installer{}).handleDirty(ctx, m)
+	assert.Nil(t, err)
+	assert.False(t, stashed)
+	assert.Equal(t, "", m.dirty)
+
+	clone()
+	_, err = testInstaller(t, Installer{}).handleDirty(ctx, m)
+	assert.Equal(t, errDirtyWorktree, err)
+	assert.Equal(t, dirtyAbort, m.dirty)
+	assert.Equal(t, errLocalChanges, classify(err))
+
+	clone()
+	m.dirty = ""
+	stashed, err = testInstaller(t, Installer{OnDirty: dirtyStash}).handleDirty(ctx, m)
+	assert.Nil(t, err)
+	assert.True(t, stashed)
+	assert.Equal(t, dirtyStash, m.dirty)
+	st, _ := gitStatus(dest)
+	assert.Equal(t, "", st)
+	assert.Nil(t, testRunner.gitStashPop(ctx, dest))
+	assert.True(t, exists(filepath.Join(dest, "untracked")))
+
+	clone()
+	stashed, err = testInstaller(t, Installer{OnDirty: dirtyReset}).handleDirty(ctx, m)
+	assert.Nil(t, err)
+	assert.False(t, stashed)
+	assert.Equal(t, dirtyReset, m.dirty)
+	st, _ = gitStatus(dest)
+	assert.Equal(t, "", st)
+
+	// --force leaves changes to checkout --force, and untracked files are kept
+	clone()
+	c := testInstaller(t, Installer{Force: true})
+	stashed, err = c.handleDirty(ctx, m)
+	assert.Nil(t, err)
+	assert.False(t, stashed)
+	assert.Equal(t, dirtyForce, m.dirty)
+	m.opts["ref"] = "master"
+	assert.Nil(t, c.checkout(ctx, m, dest, false))
+	delete(m.opts, "ref")
+	st, _ = gitStatus(dest)
+	assert.Equal(t, "?? untracked\n", st)
+
+	clone()
+	_, err = testInstaller(t, Installer{OnDirty: dirtyBackup}).handleDirty(ctx, m)
+	assert.Nil(t, err)
+	assert.False(t, exists(dest))
+	bs, _ := filepath.Glob(filepath.Join(dir, ".foo.backup-*"))
+	assert.Equal(t, 1, len(bs))
+}
+
+func TestBackupPath(t *testing.T) {
+	d := time.Date(2018, 4, 30, 12, 34, 56, 0, time.UTC)
+	assert.Equal(t, "modules/.foo.backup-20180430123456", backupPath("modules/foo", d))
+}
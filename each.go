@@ -21,7 +21,7 @@ type params struct {
 }
 
 func (g *Git) Each(path string, cmds []string, opts eachOpts) {
-	mods := parse(path)
+	mods := g.Selector.Select(parse(path))
 	out := os.Stdout
 	for _, mod := range mods {
 		c, err := makeEachArgs(cmds, mod)
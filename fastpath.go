@@ -0,0 +1,70 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+var sha1Pattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// isSha1 reports whether ref is a sha1 of a commit, not a branch or a tag.
+func isSha1(dest, ref string) (bool, error) {
+	if !sha1Pattern.MatchString(ref) {
+		return false, nil
+	}
+	return isCommit(dest, ref)
+}
+
+// upToDate reports whether an installed module is already at its target
+// so that network access can be skipped. A tag or a commit is immutable,
+// and a branch is compared with the remote head by ls-remote if lsRemote is true.
+// The module must have no local changes and the same URL of origin.
+func (c Installer) upToDate(ctx context.Context, m *Mod) bool {
+	dest := m.Dest()
+	if gitConfig(dest, "remote.origin.url") != m.opts["git"] {
+		return false
+	}
+	if st, err := localChanges(dest); err != nil || strings.TrimSpace(st) != "" {
+		return false
+	}
+	if c.Submodules || m.Submodules() {
+		out, err := gitSubmoduleStatus(dest)
+		if err != nil || len(submoduleProblems(out)) > 0 {
+			return false
+		}
+	}
+
+	ref := m.Ref()
+	if ref == "" {
+		ref = "master"
+	}
+	head := gitSha1(dest, "HEAD")
+	if head == "" {
+		return false
+	}
+	// errors are returned by checkout in the full install
+	tag, err := isTag(dest, ref)
+	if err != nil {
+		return false
+	}
+	if tag {
+		return gitSha1(dest, ref) == head
+	}
+	sha1, err := isSha1(dest, ref)
+	if err != nil {
+		return false
+	}
+	if sha1 {
+		return strings.HasPrefix(head, ref)
+	}
+	if c.LsRemote && gitSymbolicRef(dest) == ref {
+		var sha string
+		err := c.limiter.do(ctx, m.opts["git"], func() (err error) {
+			sha, err = c.gitLsRemote(ctx, dest, "refs/heads/"+ref)
+			return err
+		})
+		return err == nil && sha == head
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpToDate(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	mdir := dir
+
+	up := filepath.Join(dir, "upstream")
+	m := &Mod{name: "foo", moddir: mdir, opts: ModOpts{"git": up, "ref": "v0.1.0"}}
+	assert.Nil(t, testRunner.gitClone(ctx, up, m.Dest()))
+	assert.Nil(t, testRunner.gitCheckout(ctx, m.Dest(), "v0.1.0", false))
+
+	c := testInstaller(t, Installer{})
+	assert.True(t, c.upToDate(ctx, m))
+
+	// commit
+	sha := gitSha1(up, "v0.1.0")
+	assert.True(t, c.upToDate(ctx, &Mod{name: "foo", moddir: mdir, opts: ModOpts{"git": up, "ref": sha}}))
+	assert.True(t, c.upToDate(ctx, &Mod{name: "foo", moddir: mdir, opts: ModOpts{"git": up, "ref": sha[:10]}}))
+
+	// different tag, URL and local changes
+	assert.False(t, c.upToDate(ctx, &Mod{name: "foo", moddir: mdir, opts: ModOpts{"git": up, "ref": "master"}}))
+	assert.False(t, c.upToDate(ctx, &Mod{name: "foo", moddir: mdir, opts: ModOpts{"git": up + ".git", "ref": "v0.1.0"}}))
+	ioutil.WriteFile(filepath.Join(m.Dest(), "untracked"), []byte("a"), 0644)
+	assert.False(t, c.upToDate(ctx, m))
+	os.Remove(filepath.Join(m.Dest(), "untracked"))
+
+	// branch is checked only with ls-remote
+	b := &Mod{name: "foo", moddir: mdir, opts: ModOpts{"git": up, "ref": "master"}}
+	assert.Nil(t, testRunner.gitCheckout(ctx, m.Dest(), "master", false))
+	assert.False(t, c.upToDate(ctx, b))
+	c.LsRemote = true
+	assert.True(t, c.upToDate(ctx, b))
+
+	cmd := exec.Command("sh", "-c", "echo d >> README && git commit -qam d")
+	cmd.Dir = up
+	assert.Nil(t, cmd.Run())
+	assert.False(t, c.upToDate(ctx, b))
+}
+
+func TestInstallForgeModUpToDate(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	up := filepath.Join(dir, "upstream")
+
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintf(w, `{"current_release": {"metadata": {"source": %q}}}`, up)
+	}))
+	defer ts.Close()
+
+	c := testInstaller(t, Installer{ForgeURL: ts.URL})
+	m := Mod{user: "tmtk75", name: "foo", version: "v0.1.0", moddir: dir, opts: ModOpts{}}
+	r := c.installResult(ctx, m)
+	assert.Nil(t, r.Err)
+	assert.Equal(t, ActionCloned, r.Action)
+	assert.Equal(t, 1, requests)
+
+	m.opts = ModOpts{}
+	r = c.installResult(ctx, m)
+	assert.Nil(t, r.Err)
+	assert.Equal(t, ActionUnchanged, r.Action)
+	assert.Equal(t, 1, requests)
+
+	// the forge is asked if it's not up to date
+	m.opts, m.version = ModOpts{}, "master"
+	r = c.installResult(ctx, m)
+	assert.Nil(t, r.Err)
+	assert.Equal(t, ActionUpdated, r.Action)
+	assert.Equal(t, 2, requests)
+}
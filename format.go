@@ -43,6 +43,12 @@ func format(mods []Mod) string {
 	sort.Sort(Mods(mods))
 	buf := bytes.NewBuffer([]byte{})
 	w := buf //bufio.NewWriter(buf)
+	for _, m := range mods {
+		if m.moduledir != "" {
+			fmt.Fprintf(w, "moduledir '%s'\n", m.moduledir)
+			break
+		}
+	}
 	for _, m := range mods {
 		_, err := fmt.Fprintln(w, m.Format())
 		//fmt.Println(i)
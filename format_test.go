@@ -15,3 +15,13 @@ mod 'bar',:git=>'cccddd',:ref=>'dev'`))
 mod 'foo', :git => 'aaabbb', :ref => 'fix/a-bug'
 `, s)
 }
+
+func TestFormatModuledir(t *testing.T) {
+	mods, _ := parsePuppetfile(r(`
+mod 'foo',:git=>'aaabbb',:ref=>'v1',:install_path=>'site'
+moduledir 'vendor'`))
+	s := format(mods)
+	assert.Equal(t, `moduledir 'vendor'
+mod 'foo', :git => 'aaabbb', :ref => 'v1', :install_path => 'site'
+`, s)
+}
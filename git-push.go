@@ -13,7 +13,7 @@ func (g *Git) PushCmds(src, dst string) {
 	srcmods := parse(src)
 	dstmods := parse(dst)
 
-	for _, srcm := range srcmods {
+	for _, srcm := range g.Selector.Select(srcmods) {
 		newm, err := findModIn(dstmods, srcm)
 		if err != nil {
 			fmt.Fprintf(g.Writer, "# %v is missing in %v\n", srcm.name, dst)
@@ -29,9 +29,10 @@ func (g *Git) PushCmds(src, dst string) {
 	}
 }
 
-func PrintGitPushCmds(remote, src, dst string) {
+func PrintGitPushCmds(remote, src, dst string, sel Selector) {
 	g := NewGit()
 	g.Remote = remote
+	g.Selector = sel
 	g.PushCmds(src, dst)
 	fmt.Print()
 }
@@ -39,6 +40,7 @@ func PrintGitPushCmds(remote, src, dst string) {
 type Git struct {
 	Writer   io.Writer
 	Remote   string
+	Selector Selector
 	IsCommit func(wd, sha1 string) bool
 	IsBranch func(wd, name string) bool
 	IsTag    func(wd, name string) bool
@@ -50,14 +52,25 @@ func NewGit() *Git {
 	return &Git{
 		Writer:   os.Stdout,
 		Remote:   "origin",
-		IsCommit: isCommit,
-		IsBranch: isBranch,
-		IsTag:    isTag,
+		IsCommit: orFalse(isCommit),
+		IsBranch: orFalse(isBranch),
+		IsTag:    orFalse(isTag),
 		Sha1:     gitSha1,
 		Diff:     gitDiff,
 	}
 }
 
+// orFalse adapts a check to Git, which logs an error and reports false.
+func orFalse(f func(wd, s string) (bool, error)) func(wd, s string) bool {
+	return func(wd, s string) bool {
+		ok, err := f(wd, s)
+		if err != nil {
+			log.Printf("[error] %v", err)
+		}
+		return ok
+	}
+}
+
 func minorVersionNumber(s string) (int, error) {
 	re := regexp.MustCompile(releaseBranchPattern).FindAllStringSubmatch(s, -1)
 	if len(re) == 0 {
@@ -1,121 +1,313 @@
 package librarianpuppetgo
 
 import (
-	"bufio"
 	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
-	"syscall"
 	"time"
 )
 
-var modulePath string = "modules"
-var timeout int = 180 // Timeout for run. This needs to be sufficient to clone each git repository.
+// runner runs commands with timeout and logs them.
+type runner struct {
+	timeout time.Duration // This needs to be sufficient to clone each git repository.
+	logger  *log.Logger
+	observe func(Invocation) // called after each command if given
+}
+
+// Invocation is a command run for a mod.
+type Invocation struct {
+	Mod      string
+	Op       string // clone, fetch, ...
+	Args     []string
+	Start    time.Time
+	Duration time.Duration
+	Bytes    int64 // received objects in bytes if git reports it
+	Err      error
+}
+
+type modKey struct{}
+
+// withMod tells the name of a mod to commands run with ctx.
+func withMod(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, modKey{}, name)
+}
+
+func modName(ctx context.Context) string {
+	n, _ := ctx.Value(modKey{}).(string)
+	return n
+}
+
+// net returns args of a network operation, which reports progress
+// to be observed.
+func (r runner) net(args ...string) []string {
+	if r.observe == nil {
+		return args
+	}
+	return append([]string{args[0], "--progress"}, args[1:]...)
+}
+
+var receivedPattern = regexp.MustCompile(`Receiving objects: +100% \(\d+/\d+\), ([\d.]+) (bytes|KiB|MiB|GiB)`)
+
+// receivedBytes returns bytes of objects received in progress of git.
+func receivedBytes(stderr string) int64 {
+	ms := receivedPattern.FindAllStringSubmatch(stderr, -1)
+	if len(ms) == 0 {
+		return 0
+	}
+	m := ms[len(ms)-1]
+	f, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0
+	}
+	unit := map[string]float64{"bytes": 1, "KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30}[m[2]]
+	return int64(f * unit)
+}
+
+// orDiscard returns l, or a logger which discards logs if l is nil.
+func orDiscard(l *log.Logger) *log.Logger {
+	if l == nil {
+		return log.New(ioutil.Discard, "", 0)
+	}
+	return l
+}
 
-func isCommit(dest, sha1 string) bool {
-	cmd := exec.Command("git", "show", "-q", sha1)
-	a := checkExitCode(dest, cmd)
-	b := !isBranch(dest, sha1)
-	c := !isTag(dest, sha1)
-	return a && b && c
+func isCommit(dest, sha1 string) (bool, error) {
+	ok, err := checkExitCode(dest, exec.Command("git", "show", "-q", sha1))
+	if !ok || err != nil {
+		return false, err
+	}
+	if ok, err = isBranch(dest, sha1); ok || err != nil {
+		return false, err
+	}
+	ok, err = isTag(dest, sha1)
+	return !ok && err == nil, err
 }
 
-func isBranch(dest, name string) bool {
+func isBranch(dest, name string) (bool, error) {
 	return isRef(dest, "heads", name)
 }
 
-func isTag(dest, tag string) bool {
+func isTag(dest, tag string) (bool, error) {
 	return isRef(dest, "tags", tag)
 }
 
-func isRef(dest, kind, tag string) bool {
+func isRef(dest, kind, tag string) (bool, error) {
 	cmd := exec.Command("git", "show-ref", "-q", "--verify", "refs/"+kind+"/"+tag)
 	return checkExitCode(dest, cmd)
 }
 
-func checkExitCode(dest string, cmd *exec.Cmd) bool {
+// checkExitCode reports whether cmd exits with 0 in dest.
+// It returns an error only if cmd cannot be run, e.g. git is missing.
+func checkExitCode(dest string, cmd *exec.Cmd) (bool, error) {
 	cmd.Dir = dest
 	err := cmd.Run()
-	if exiterr, ok := err.(*exec.ExitError); ok {
-		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-			return status.ExitStatus() == 0
-		}
+	if _, ok := err.(*exec.ExitError); ok {
+		return false, nil
 	}
 	if err != nil {
-		log.Fatalf("[error] %v\t%v\t%v\n", err, dest)
+		return false, fmt.Errorf("%v in %v: %v", strings.Join(cmd.Args, " "), dest, err)
 	}
-	return true
+	return true, nil
 }
 
 func gitSha1(wd, ref string) string {
 	buf := bytes.NewBuffer([]byte{})
-	w := bufio.NewWriter(buf)
-	run2(w, wd, "git", []string{"log", ref, "-s", "--format=%H", "-n1"})
+	run2(buf, wd, "git", []string{"log", ref, "-s", "--format=%H", "-n1"})
+	return strings.TrimSpace(buf.String())
+}
+
+// gitHead returns sha1 of HEAD, or empty if dest is not a git repository.
+func gitHead(dest string) string {
+	if !exists(filepath.Join(dest, ".git")) {
+		return ""
+	}
+	buf := bytes.NewBuffer([]byte{})
+	run3(buf, ioutil.Discard, dest, "git", []string{"rev-parse", "-q", "--verify", "HEAD"})
+	return strings.TrimSpace(buf.String())
+}
+
+func (r runner) gitClone(ctx context.Context, url, dest string) error {
+	return r.run(ctx, "", "git", r.net("clone", url, dest))
+}
+
+func (r runner) gitFetch(ctx context.Context, dest string) error {
+	return r.run(ctx, dest, "git", r.net("fetch", "-p"))
+}
+
+func (r runner) gitFetchRefs(ctx context.Context, dest, url, refspec string) error {
+	return r.run(ctx, dest, "git", []string{"fetch", url, refspec})
+}
+
+func (r runner) gitPull(ctx context.Context, dest, ref string) error {
+	return r.run(ctx, dest, "git", r.net("pull", "origin", ref))
+}
+
+func (r runner) gitSubmoduleUpdate(ctx context.Context, dest string) error {
+	if err := r.run(ctx, dest, "git", []string{"submodule", "sync", "--recursive"}); err != nil {
+		return err
+	}
+	return r.run(ctx, dest, "git", []string{"submodule", "update", "--init", "--recursive"})
+}
+
+func gitSubmoduleStatus(dest string) (string, error) {
+	buf := bytes.NewBuffer([]byte{})
+	err := run3(buf, os.Stderr, dest, "git", []string{"submodule", "status", "--recursive"})
+	return buf.String(), err
+}
+
+// gitStatus returns changes including untracked files in short format.
+func gitStatus(dest string) (string, error) {
+	buf := bytes.NewBuffer([]byte{})
+	err := run3(buf, os.Stderr, dest, "git", []string{"status", "--porcelain"})
+	return buf.String(), err
+}
+
+func (r runner) gitStash(ctx context.Context, dest string) error {
+	return r.run(ctx, dest, "git", []string{"stash", "push", "--include-untracked", "-m", "librarian-puppet-go"})
+}
+
+func (r runner) gitStashPop(ctx context.Context, dest string) error {
+	return r.run(ctx, dest, "git", []string{"stash", "pop"})
+}
+
+// gitResetTo moves the current branch to ref discarding local commits.
+func (r runner) gitResetTo(ctx context.Context, dest, ref string) error {
+	return r.run(ctx, dest, "git", []string{"reset", "--hard", ref})
+}
+
+// gitResetHard discards local changes and untracked files except ignored ones.
+func (r runner) gitResetHard(ctx context.Context, dest string) error {
+	if err := r.run(ctx, dest, "git", []string{"reset", "--hard"}); err != nil {
+		return err
+	}
+	return r.run(ctx, dest, "git", []string{"clean", "-fd"})
+}
+
+func gitConfig(dest, key string) string {
+	buf := bytes.NewBuffer([]byte{})
+	run3(buf, os.Stderr, dest, "git", []string{"config", "--get", key})
+	return strings.TrimSpace(buf.String())
+}
+
+// gitSymbolicRef returns the branch name checked out, or empty if HEAD is detached.
+func gitSymbolicRef(dest string) string {
+	buf := bytes.NewBuffer([]byte{})
+	run3(buf, bytes.NewBuffer([]byte{}), dest, "git", []string{"symbolic-ref", "-q", "--short", "HEAD"})
 	return strings.TrimSpace(buf.String())
 }
 
-func gitClone(url, dest string) error {
-	return run("", "git", []string{"clone", url, dest})
+// gitLsRemote returns sha1 of ref in origin.
+func (r runner) gitLsRemote(ctx context.Context, dest, ref string) (string, error) {
+	buf := bytes.NewBuffer([]byte{})
+	if err := r.runOut(ctx, buf, dest, "git", []string{"ls-remote", "origin", ref}); err != nil {
+		return "", err
+	}
+	f := strings.Fields(buf.String())
+	if len(f) == 0 {
+		return "", nil
+	}
+	return f[0], nil
 }
 
-func gitFetch(dest string) error {
-	return run(dest, "git", []string{"fetch", "-p"})
+// gitLsRemoteURL returns sha1 of refs matching patterns in a repository at url by name.
+func (r runner) gitLsRemoteURL(ctx context.Context, url string, patterns ...string) (map[string]string, error) {
+	buf := bytes.NewBuffer([]byte{})
+	if err := r.runOut(ctx, buf, "", "git", append([]string{"ls-remote", url}, patterns...)); err != nil {
+		return nil, err
+	}
+	refs := map[string]string{}
+	for _, l := range strings.Split(buf.String(), "\n") {
+		if f := strings.Fields(l); len(f) == 2 {
+			refs[f[1]] = f[0]
+		}
+	}
+	return refs, nil
 }
 
-func gitPull(dest, ref string) error {
-	return run(dest, "git", []string{"pull", "origin", ref})
+// hasCommit reports whether sha1 is a commit in dest.
+func hasCommit(dest, sha1 string) (bool, error) {
+	return checkExitCode(dest, exec.Command("git", "cat-file", "-e", sha1+"^{commit}"))
 }
 
-func gitSetUrl(dest, url string) error {
-	return run(dest, "git", []string{"remote", "set-url", "origin", url})
+func (r runner) gitSetUrl(ctx context.Context, dest, url string) error {
+	return r.run(ctx, dest, "git", []string{"remote", "set-url", "origin", url})
 }
 
-func gitCheckout(dest, ref string, force bool) error {
+func (r runner) gitCheckout(ctx context.Context, dest, ref string, force bool) error {
 	if ref == "" {
 		ref = "master"
 	}
 	if force {
-		return run(dest, "git", []string{"checkout", "--force", ref})
+		return r.run(ctx, dest, "git", []string{"checkout", "--force", ref})
 	}
-	return run(dest, "git", []string{"checkout", ref})
+	return r.run(ctx, dest, "git", []string{"checkout", ref})
+}
+
+func (r runner) run(ctx context.Context, wd, s string, args []string) error {
+	return r.runOut(ctx, nil, wd, s, args)
+}
+
+// runOut runs a command with timeout writing stdout to w.
+func (r runner) runOut(ctx context.Context, w io.Writer, wd, s string, args []string) error {
+	return r.runEnv(ctx, w, nil, wd, s, args)
 }
 
-func run(wd, s string, args []string) error {
-	d := time.Duration(timeout) * time.Second
-	ctx, cancel := context.WithTimeout(context.Background(), d)
+// runEnv runs a command with environment variables added to the current ones.
+func (r runner) runEnv(ctx context.Context, w io.Writer, env []string, wd, s string, args []string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, s, args...)
 	cmd.Dir = wd
+	cmd.Stdout = w
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	buf := bytes.NewBuffer([]byte{})
-	cmd.Stderr = bufio.NewWriter(buf)
-	logger.Printf("start: %v %v in %v", s, args, wd)
+	cmd.Stderr = buf
+	r.logger.Printf("start: %v %v in %v", s, args, wd)
 	now := time.Now()
 	err := cmd.Run()
+	elapsed := time.Since(now)
 
-	if ctx.Err() == context.DeadlineExceeded {
-		log.Printf("[cancel] %v\t%v\t%v\t%v\n", err, args, buf, d)
-		return ctx.Err()
+	if r.observe != nil {
+		e := err
+		if ctx.Err() != nil {
+			e = ctx.Err()
+		}
+		op := s // such as sh of hooks
+		if s == "git" && len(args) > 0 {
+			op = args[0]
+		}
+		r.observe(Invocation{Mod: modName(ctx), Op: op, Args: args, Start: now, Duration: elapsed, Bytes: receivedBytes(buf.String()), Err: e})
+	}
+	if ctx.Err() != nil {
+		r.logger.Printf("[cancel] %v\t%v\t%v\t%v\n", err, args, buf, elapsed)
+		return newGitError(s, args, wd, buf.String(), elapsed, ctx.Err())
 	}
 	prefix := "done"
 	if err != nil {
 		prefix = "error"
-		log.Printf("[error] %v\t%v\t%v\n", err, args, buf)
+		r.logger.Printf("[error] %v\t%v\t%v\n", err, args, buf)
+		err = newGitError(s, args, wd, buf.String(), elapsed, err)
 	}
 
-	elapsed := time.Since(now)
-	logger.Printf("%v: %v %v %v in %v", prefix, elapsed, s, args, wd)
+	r.logger.Printf("%v: %v %v %v in %v", prefix, elapsed, s, args, wd)
 	return err
 }
 
 func gitDiff(wd, aref, bref string) string {
 	buf := bytes.NewBuffer([]byte{})
-	w := bufio.NewWriter(buf)
-	run2(w, wd, "git", []string{"--no-pager", "diff", "-w", aref, bref})
+	run2(buf, wd, "git", []string{"--no-pager", "diff", "-w", aref, bref})
 	return buf.String()
 }
 
@@ -2,25 +2,53 @@ package librarianpuppetgo
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestIsCommit(t *testing.T) {
-	assert.True(t, isCommit(".", "f8d13558bafc452e6994c015ac807e367e0fb557"))
-	assert.False(t, isCommit(".", "v0.1.0"))
-	assert.False(t, isCommit(".", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"))
+	ok, err := isCommit(".", "f8d13558bafc452e6994c015ac807e367e0fb557")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, _ = isCommit(".", "v0.1.0")
+	assert.False(t, ok)
+	ok, _ = isCommit(".", "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx")
+	assert.False(t, ok)
 }
 
 func TestIsTag(t *testing.T) {
-	assert.True(t, isTag(".", "v0.1.0"))
-	assert.False(t, isTag(".", "no-tag"))
+	ok, err := isTag(".", "v0.1.0")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, _ = isTag(".", "no-tag")
+	assert.False(t, ok)
 }
 
 func TestIsBranch(t *testing.T) {
-	assert.True(t, isBranch(".", "master"))
-	assert.False(t, isBranch(".", "no-branch"))
+	ok, err := isBranch(".", "master")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, _ = isBranch(".", "no-branch")
+	assert.False(t, ok)
+}
+
+func TestCheckExitCode(t *testing.T) {
+	ok, err := checkExitCode(".", exec.Command("true"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = checkExitCode(".", exec.Command("false"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	// an error is returned instead of exit
+	ok, err = checkExitCode("/no/such/dir", exec.Command("git", "status"))
+	assert.NotNil(t, err)
+	assert.False(t, ok)
 }
 
 func TestGitSha1(t *testing.T) {
@@ -28,12 +56,48 @@ func TestGitSha1(t *testing.T) {
 }
 
 func TestRunTimeout(t *testing.T) {
-	timeout = 2
-	err := run(".", "sleep", []string{"10"}) // timed out in 2 seconds
+	r := runner{timeout: 2 * time.Second, logger: logger}
+	err := r.run(context.Background(), ".", "sleep", []string{"10"}) // timed out in 2 seconds
 	if err == nil {
 		t.Errorf("should be error")
 	}
-	if err != context.DeadlineExceeded {
+	if e, ok := err.(*GitError); !ok || e.Err != context.DeadlineExceeded {
 		t.Errorf("should be error %v", err)
 	}
 }
+
+var testRunner = runner{timeout: time.Minute, logger: logger}
+
+// newTestRepo creates an upstream repository with tag v0.1.0 and branches
+// master and develop, and returns a directory containing it as "upstream".
+func newTestRepo(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "lpg-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// commit and stash need an identity
+	for k, v := range map[string]string{
+		"GIT_AUTHOR_NAME": "t", "GIT_AUTHOR_EMAIL": "t@example.com",
+		"GIT_COMMITTER_NAME": "t", "GIT_COMMITTER_EMAIL": "t@example.com",
+	} {
+		os.Setenv(k, v)
+	}
+	up := filepath.Join(dir, "upstream")
+	for _, c := range []string{
+		"git init -q upstream",
+		"cd upstream && git checkout -q -b master",
+		"cd upstream && echo a > README && git add README && git commit -qm a && git tag v0.1.0",
+		"cd upstream && echo b >> README && git commit -qam b",
+		"cd upstream && git checkout -q -b develop && echo c >> README && git commit -qam c && git checkout -q master",
+	} {
+		cmd := exec.Command("sh", "-c", c)
+		cmd.Dir = dir
+		if b, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v: %s", c, err, b)
+		}
+	}
+	if !exists(up) {
+		t.Fatalf("%v is missing", up)
+	}
+	return dir
+}
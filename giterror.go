@@ -0,0 +1,149 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// GitError is returned when a git command fails or times out.
+type GitError struct {
+	Op       string // clone, fetch, pull, checkout, ...
+	Args     []string
+	Dir      string
+	ExitCode int // -1 if the command didn't exit by itself
+	Stderr   string
+	Duration time.Duration
+	Err      error // *exec.ExitError, context.DeadlineExceeded, ...
+}
+
+func newGitError(s string, args []string, wd, stderr string, d time.Duration, err error) *GitError {
+	e := &GitError{
+		Op:       s,
+		Args:     args,
+		Dir:      wd,
+		ExitCode: -1,
+		Stderr:   stderr,
+		Duration: d,
+		Err:      err,
+	}
+	if s == "git" {
+		for _, a := range args {
+			if !strings.HasPrefix(a, "-") {
+				e.Op = a
+				break
+			}
+		}
+	}
+	if exiterr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			e.ExitCode = status.ExitStatus()
+		}
+	}
+	return e
+}
+
+func (e *GitError) Error() string {
+	if l := lastLine(e.Stderr); l != "" {
+		return fmt.Sprintf("%v: %v: %v", e.Op, e.Err, l)
+	}
+	return fmt.Sprintf("%v: %v", e.Op, e.Err)
+}
+
+// lastLine returns the last line beginning with fatal: or error:,
+// or the last line if there is no such line.
+func lastLine(s string) string {
+	ls := strings.Split(strings.TrimSpace(s), "\n")
+	for i := len(ls) - 1; i >= 0; i-- {
+		l := strings.TrimSpace(ls[i])
+		if strings.HasPrefix(l, "fatal:") || strings.HasPrefix(l, "error:") {
+			return l
+		}
+	}
+	return strings.TrimSpace(ls[len(ls)-1])
+}
+
+// Classes of errors given by classify.
+const (
+	errTimeout        = "timeout"
+	errCanceled       = "canceled"
+	errAuth           = "auth failure"
+	errRepoNotFound   = "repository not found"
+	errRefNotFound    = "ref not found"
+	errNetwork        = "network error"
+	errLocalChanges   = "local changes"
+	errMergeConflict  = "merge conflict"
+	errUnknownFailure = "unknown"
+)
+
+var errorClasses = []struct {
+	class    string
+	messages []string
+}{
+	{errAuth, []string{
+		"authentication failed",
+		"permission denied (publickey",
+		"could not read username",
+		"host key verification failed",
+	}},
+	{errRepoNotFound, []string{
+		"repository not found",
+		"does not appear to be a git repository",
+	}},
+	{errRefNotFound, []string{
+		"couldn't find remote ref",
+		"did not match any file(s) known to git",
+		"unknown revision",
+		"not a valid object name",
+	}},
+	{errTimeout, []string{
+		"timed out",
+	}},
+	{errNetwork, []string{
+		"could not resolve host",
+		"connection refused",
+		"connection reset",
+		"network is unreachable",
+		"no route to host",
+		"the remote end hung up unexpectedly",
+		"early eof",
+	}},
+	{errLocalChanges, []string{
+		"would be overwritten by",
+	}},
+	{errMergeConflict, []string{
+		"automatic merge failed",
+		"not possible to fast-forward",
+	}},
+}
+
+// classify returns a short description of the cause of err.
+func classify(err error) string {
+	e, ok := err.(*GitError)
+	if ok {
+		err = e.Err
+	}
+	switch err {
+	case context.DeadlineExceeded:
+		return errTimeout
+	case context.Canceled:
+		return errCanceled
+	case errDirtyWorktree:
+		return errLocalChanges
+	}
+	if !ok {
+		return errUnknownFailure
+	}
+	s := strings.ToLower(e.Stderr)
+	for _, c := range errorClasses {
+		for _, m := range c.messages {
+			if strings.Contains(s, m) {
+				return c.class
+			}
+		}
+	}
+	return errUnknownFailure
+}
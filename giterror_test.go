@@ -0,0 +1,51 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitError(t *testing.T) {
+	err := testRunner.run(context.Background(), ".", "sh", []string{"-c", "echo 'fatal: a' >&2; echo 'fatal: b' >&2; exit 3"})
+	e, ok := err.(*GitError)
+	assert.True(t, ok)
+	assert.Equal(t, "sh", e.Op)
+	assert.Equal(t, ".", e.Dir)
+	assert.Equal(t, 3, e.ExitCode)
+	assert.Equal(t, "fatal: a\nfatal: b\n", e.Stderr)
+	assert.Equal(t, "sh: exit status 3: fatal: b", e.Error())
+
+	e = newGitError("git", []string{"--no-pager", "fetch", "-p"}, "modules/foo", "", 0, errors.New("x"))
+	assert.Equal(t, "fetch", e.Op)
+	assert.Equal(t, -1, e.ExitCode)
+	assert.Equal(t, "fetch: x", e.Error())
+
+	e.Stderr = "fatal: Could not read from remote repository.\n\nPlease make sure you have the correct access rights\nand the repository exists.\n"
+	assert.Equal(t, "fetch: x: fatal: Could not read from remote repository.", e.Error())
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err error
+		exp string
+	}{
+		{&GitError{Err: context.DeadlineExceeded}, errTimeout},
+		{context.DeadlineExceeded, errTimeout},
+		{&GitError{Err: context.Canceled, Stderr: "fatal: early EOF"}, errCanceled},
+		{&GitError{Stderr: "Permission denied (publickey).\nfatal: Could not read from remote repository."}, errAuth},
+		{&GitError{Stderr: "ERROR: Repository not found.\nfatal: Could not read from remote repository."}, errRepoNotFound},
+		{&GitError{Stderr: "fatal: couldn't find remote ref no-branch"}, errRefNotFound},
+		{&GitError{Stderr: "error: pathspec 'v9.9.9' did not match any file(s) known to git."}, errRefNotFound},
+		{&GitError{Stderr: "ssh: Could not resolve hostname github.com: nodename nor servname provided"}, errNetwork},
+		{&GitError{Stderr: "fatal: unable to access 'https://github.com/a/b/': Could not resolve host: github.com"}, errNetwork},
+		{&GitError{Stderr: "error: Your local changes to the following files would be overwritten by checkout:"}, errLocalChanges},
+		{&GitError{Stderr: "something else"}, errUnknownFailure},
+		{errors.New("not a git error"), errUnknownFailure},
+	}
+	for _, c := range tests {
+		assert.Equal(t, c.exp, classify(c.err), "%v", c.err)
+	}
+}
@@ -0,0 +1,157 @@
+package librarianpuppetgo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Names of hooks, which are also options of a mod, e.g. :post_install => 'make'.
+const (
+	hookPreInstall  = "pre_install"
+	hookPostInstall = "post_install"
+)
+
+// preInstall runs global hooks and then one of m before git operations.
+func (c Installer) preInstall(ctx context.Context, m *Mod) error {
+	hooks := append(append([]string{}, c.PreInstall...), m.opts[hookPreInstall])
+	return c.runHooks(ctx, m, hookPreInstall, hooks, Result{OldSha1: gitHead(m.Dest())})
+}
+
+// hookStateFile in the git directory of a mod records the worktree after
+// post_install hooks, so that files they change are not local changes.
+const hookStateFile = "librarian-puppet-go-hook-state"
+
+// postInstall runs a hook of m and then global ones after install succeeds,
+// and records changes made by them.
+func (c Installer) postInstall(ctx context.Context, m *Mod, r Result) error {
+	hooks := append([]string{m.opts[hookPostInstall]}, c.PostInstall...)
+	if err := c.runHooks(ctx, m, hookPostInstall, hooks, r); err != nil {
+		return err
+	}
+	p := filepath.Join(m.Dest(), ".git", hookStateFile)
+	if !hasHooks(hooks) {
+		os.Remove(p)
+		return nil
+	}
+	st, err := gitStatus(m.Dest())
+	if err != nil {
+		return err
+	}
+	s, err := worktreeState(m.Dest(), st)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, []byte(s), 0644)
+}
+
+// worktreeState returns a hash of changes of tracked files in dest and
+// its status st, which lists untracked files.
+func worktreeState(dest, st string) (string, error) {
+	var b bytes.Buffer
+	if err := run3(&b, ioutil.Discard, dest, "git", []string{"diff", "HEAD", "--binary"}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x\n%v", sha256.Sum256(b.Bytes()), st), nil
+}
+
+func hasHooks(hooks []string) bool {
+	for _, h := range hooks {
+		if h != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// localChanges returns git status of dest, or empty if the worktree is
+// the same as post_install hooks left it.
+func localChanges(dest string) (string, error) {
+	st, err := gitStatus(dest)
+	if err != nil || strings.TrimSpace(st) == "" {
+		return st, err
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dest, ".git", hookStateFile))
+	if err != nil {
+		return st, nil
+	}
+	s, err := worktreeState(dest, st)
+	if err != nil {
+		return "", err
+	}
+	if s == string(b) {
+		return "", nil
+	}
+	return st, nil
+}
+
+// discardHookChanges reverts tracked files changed by post_install hooks
+// so that checkout doesn't conflict with them. Files they generate are left.
+func (c Installer) discardHookChanges(ctx context.Context, m *Mod) error {
+	p := filepath.Join(m.Dest(), ".git", hookStateFile)
+	if !exists(p) {
+		return nil
+	}
+	if err := c.run(ctx, m.Dest(), "git", []string{"reset", "-q", "--hard"}); err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+// runHooks runs scripts by sh in m.Dest(), or the module path if it's
+// not installed yet, with environment variables describing m.
+func (c Installer) runHooks(ctx context.Context, m *Mod, name string, hooks []string, r Result) error {
+	dir := m.Dest()
+	if !exists(dir) {
+		dir = filepath.Dir(dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	env := hookEnv(*m, name, r)
+	for _, h := range hooks {
+		if h == "" {
+			continue
+		}
+		c.phase(m, name)
+		c.logger.Printf("%v: %v in %v", name, h, dir)
+		var b bytes.Buffer
+		err := c.runEnv(ctx, &b, env, dir, "sh", []string{"-c", h})
+		if b.Len() > 0 {
+			c.logger.Printf("%v: %v: %s", name, m.name, b.Bytes())
+		}
+		if err != nil {
+			if e, ok := err.(*GitError); ok {
+				e.Op = name
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// hookEnv returns environment variables given to hooks. Sha1 after install
+// and the action are empty for pre_install.
+func hookEnv(m Mod, name string, r Result) []string {
+	dest, err := filepath.Abs(m.Dest())
+	if err != nil {
+		dest = m.Dest()
+	}
+	return []string{
+		"LP_HOOK=" + name,
+		"LP_MOD_NAME=" + m.name,
+		"LP_MOD_USER=" + m.user,
+		"LP_MOD_VERSION=" + m.version,
+		"LP_MOD_GIT=" + m.opts["git"],
+		"LP_MOD_REF=" + m.Ref(),
+		"LP_MOD_DEST=" + dest,
+		"LP_OLD_SHA1=" + r.OldSha1,
+		"LP_NEW_SHA1=" + r.NewSha1,
+		"LP_ACTION=" + r.Action,
+	}
+}
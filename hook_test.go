@@ -0,0 +1,139 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallHooks(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	up := filepath.Join(dir, "upstream")
+
+	pf, err := ParsePuppetfile(strings.NewReader(
+		"mod 'foo', :git => '"+up+"', :ref => 'master', :post_install => 'echo \"$LP_OLD_SHA1,$LP_NEW_SHA1,$LP_ACTION, x\" > ../post'\n"),
+		ParseOptions{})
+	assert.Nil(t, err)
+	m := pf.Mods[0]
+	m.moddir = dir
+	c := testInstaller(t, Installer{
+		PreInstall:  []string{"echo $LP_MOD_NAME $LP_HOOK >> " + filepath.Join(dir, "pre")},
+		PostInstall: []string{`test "$PWD" = "$LP_MOD_DEST"`},
+	})
+
+	r := c.installResult(ctx, m)
+	assert.Nil(t, r.Err)
+	assert.Equal(t, ActionCloned, r.Action)
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "pre"))
+	assert.Equal(t, "foo pre_install\n", string(b))
+	b, _ = ioutil.ReadFile(filepath.Join(dir, "post"))
+	assert.Equal(t, ","+r.NewSha1+",cloned, x\n", string(b))
+
+	r = c.installResult(ctx, m)
+	assert.Nil(t, r.Err)
+	b, _ = ioutil.ReadFile(filepath.Join(dir, "post"))
+	assert.Equal(t, r.NewSha1+","+r.NewSha1+",unchanged, x\n", string(b))
+}
+
+func TestInstallHookFailure(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	up := filepath.Join(dir, "upstream")
+	m := Mod{name: "foo", moddir: dir, opts: ModOpts{"git": up, "ref": "master", "post_install": "echo broken >&2; exit 3"}}
+
+	c := testInstaller(t, Installer{})
+	r := c.installResult(ctx, m)
+	assert.Equal(t, ActionFailed, r.Action)
+	assert.Equal(t, hookPostInstall, r.Op)
+	assert.Contains(t, r.Err.Error(), "broken")
+	assert.Equal(t, gitSha1(up, "master"), r.NewSha1)
+
+	c = testInstaller(t, Installer{PreInstall: []string{"false"}})
+	m.opts = ModOpts{"git": up, "ref": "develop"}
+	r = c.installResult(ctx, m)
+	assert.Equal(t, ActionFailed, r.Action)
+	assert.Equal(t, hookPreInstall, r.Op)
+	assert.Equal(t, r.OldSha1, r.NewSha1)
+}
+
+func TestInstallHookChanges(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	up := filepath.Join(dir, "upstream")
+	m := Mod{name: "foo", moddir: dir, opts: ModOpts{"git": up, "ref": "v0.1.0", "post_install": "touch generated && rm -f README"}}
+
+	var ops []string
+	c := testInstaller(t, Installer{OnGit: func(inv Invocation) { ops = append(ops, inv.Op) }})
+	r := c.installResult(ctx, m)
+	assert.Nil(t, r.Err)
+	assert.Equal(t, ActionCloned, r.Action)
+
+	// files changed by the hook are not local changes, and no network is needed
+	ops = nil
+	r = c.installResult(ctx, m)
+	assert.Nil(t, r.Err)
+	assert.Equal(t, ActionUnchanged, r.Action)
+	assert.NotContains(t, ops, "fetch")
+
+	// the README removed by the hook doesn't conflict with checkout
+	m.opts["ref"] = "master"
+	r = c.installResult(ctx, m)
+	assert.Nil(t, r.Err)
+	assert.Equal(t, ActionUpdated, r.Action)
+	assert.True(t, exists(filepath.Join(dir, "foo", "generated")))
+
+	// changes by others are still local changes
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "foo", "local"), nil, 0644))
+	m.opts["ref"] = "v0.1.0"
+	r = c.installResult(ctx, m)
+	assert.Equal(t, ActionFailed, r.Action)
+	assert.Equal(t, errDirtyWorktree, r.Err)
+}
+
+func TestInstallHookQuotes(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	up := filepath.Join(dir, "upstream")
+
+	pf, err := ParsePuppetfile(strings.NewReader(
+		"mod 'foo', :git => '"+up+"', :post_install => \"touch a.pyc && echo '*.pyc' > ../post\"\n"),
+		ParseOptions{})
+	assert.Nil(t, err)
+	m := pf.Mods[0]
+	m.moddir = dir
+	r := testInstaller(t, Installer{}).installResult(context.Background(), m)
+	assert.Nil(t, r.Err)
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "post"))
+	assert.Equal(t, "*.pyc\n", string(b)) // not expanded to a.pyc
+}
+
+func TestInstallHookChangesEdited(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	up := filepath.Join(dir, "upstream")
+	m := Mod{name: "foo", moddir: dir, opts: ModOpts{"git": up, "ref": "v0.1.0", "post_install": "echo hook > README"}}
+
+	c := testInstaller(t, Installer{})
+	r := c.installResult(ctx, m)
+	assert.Nil(t, r.Err)
+
+	// the status is the same as the hook left, but the content is not
+	readme := filepath.Join(dir, "foo", "README")
+	assert.Nil(t, ioutil.WriteFile(readme, []byte("edited\n"), 0644))
+	m.opts["ref"] = "master"
+	r = c.installResult(ctx, m)
+	assert.Equal(t, ActionFailed, r.Action)
+	assert.Equal(t, errDirtyWorktree, r.Err)
+	b, _ := ioutil.ReadFile(readme)
+	assert.Equal(t, "edited\n", string(b))
+}
@@ -0,0 +1,131 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultHost is a key of a limit for hosts not given explicitly.
+const defaultHost = "*"
+
+var scpLike = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):`)
+
+// gitHost returns the host of a git URL, which is one of
+// ssh://[user@]host[:port]/path, https://host/path and [user@]host:path.
+// It's empty for a local repository.
+func gitHost(u string) string {
+	if strings.Contains(u, "://") {
+		p, err := url.Parse(u)
+		if err != nil || p.Scheme == "file" {
+			return ""
+		}
+		return strings.ToLower(p.Hostname())
+	}
+	if m := scpLike.FindStringSubmatch(u); m != nil {
+		return strings.ToLower(m[1])
+	}
+	return ""
+}
+
+// parseHostLimits parses HOST=N given in command line.
+func parseHostLimits(args []string) (map[string]int, error) {
+	ls := map[string]int{}
+	for _, a := range args {
+		i := strings.LastIndex(a, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("host limit must be HOST=N: %v", a)
+		}
+		n, err := strconv.Atoi(a[i+1:])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("host limit must be a positive number: %v", a)
+		}
+		ls[strings.ToLower(a[:i])] = n
+	}
+	return ls, nil
+}
+
+// limiter limits concurrent network operations for each host
+// and starts of them in all hosts.
+type limiter struct {
+	limits   map[string]int
+	interval time.Duration // between starts, no limit if 0
+
+	mu   sync.Mutex
+	sems map[string]chan struct{}
+	next time.Time
+}
+
+func newLimiter(limits map[string]int, rate float64) *limiter {
+	l := &limiter{limits: limits, sems: map[string]chan struct{}{}}
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return l
+}
+
+// do calls f for a repository at u when a slot of the host is available
+// and the rate allows.
+func (l *limiter) do(ctx context.Context, u string, f func() error) error {
+	if sem := l.sem(gitHost(u)); sem != nil {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err := l.wait(ctx); err != nil {
+		return err
+	}
+	return f()
+}
+
+func (l *limiter) sem(host string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s, ok := l.sems[host]; ok {
+		return s
+	}
+	n, ok := l.limits[host]
+	if !ok {
+		n, ok = l.limits[defaultHost]
+	}
+	if !ok || host == "" {
+		l.sems[host] = nil
+		return nil
+	}
+	l.sems[host] = make(chan struct{}, n)
+	return l.sems[host]
+}
+
+// wait blocks until the next start is allowed.
+func (l *limiter) wait(ctx context.Context) error {
+	if l.interval == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	d := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	if d == 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -2,14 +2,18 @@ package librarianpuppetgo
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
-	"sync"
+	"strings"
+	"time"
 )
 
 var newReader func(string) io.ReadCloser = readFromFile
@@ -22,126 +26,554 @@ func readFromFile(n string) io.ReadCloser {
 	return r
 }
 
+// Installer installs mods into ModulePath. The zero value is ready to use.
+// It has no state but the configuration, and unexported fields are made
+// of it by Install, so installs into different paths can run concurrently.
+type Installer struct {
+	ModulePath  string        // "modules" if empty
+	Concurrency int           // mods installed at once, all of them if 0 or negative
+	Timeout     time.Duration // for each git operation, 3 minutes if 0
+	Logger      *log.Logger   // verbose logs, discarded if nil
+
+	Force          bool             // checkout with --force, which discards changes of tracked files unless OnDirty is given
+	OnlyCheckout   bool             // checkout without network access
+	Submodules     bool             // update git submodules of all mods
+	Retries        int              // retries to clone, fetch or pull
+	RetryWait      time.Duration    // wait before the first retry, doubled for each retry
+	Rewrites       []Rewrite        // rules to rewrite git URLs, the first matched one is applied
+	OnDirty        string           // abort, stash, reset or backup for local changes
+	Atomic         bool             // prepare each mod in a staging directory
+	LsRemote       bool             // skip fetch of a branch if ls-remote shows it's at the head
+	BranchStrategy string           // pull or reset for a branch, reset if empty
+	HostLimits     map[string]int   // network operations at once for each host of git URLs, "*" for the others
+	Rate           float64          // network operations started per second in all hosts, no limit if 0
+	PreInstall     []string         // scripts run by sh in each mod before git operations
+	PostInstall    []string         // scripts run by sh in each mod after it's installed
+	OnGit          func(Invocation) // called concurrently by workers after each git command
+	OnEvent        func(Event)      // called concurrently by workers as each mod makes progress
+	Metrics        *Metrics         // records installs, git commands and Forge requests if given
+	ForgeURL       string           // endpoint of the Forge API, https://forgeapi.puppetlabs.com if empty
+	HTTPClient     *http.Client     // for the Forge API, http.DefaultClient if nil
+
+	runner
+	rewriter rewriter
+	limiter  *limiter
+}
+
+const defaultTimeout = 3 * time.Minute
+
+// Install installs mods and returns results in the order of mods.
+// It returns an error only if the configuration of c is invalid.
+// Mods which are not started before ctx is done are returned as canceled.
+func (c Installer) Install(ctx context.Context, mods []Mod) ([]Result, error) {
+	c, err := c.setup()
+	if err != nil {
+		return nil, err
+	}
+	ms := make([]Mod, len(mods))
+	for i, m := range mods {
+		m.opts = m.Opts() // installMod rewrites :git
+		m.moddir = c.ModulePath
+		ms[i] = m
+	}
+	c.logger.Printf("mods size: %v, concurrency: %v, timeout: %v", len(ms), c.Concurrency, c.Timeout)
+
+	results := pool(ctx, c.Concurrency, ms, c.installResult)
+	if ctx.Err() != nil {
+		c.logger.Printf("[cancel] %v", ctx.Err())
+	}
+	if c.Metrics != nil {
+		c.Metrics.observeInstall(c.ModulePath, results, time.Now())
+	}
+	return results, nil
+}
+
+func (c Installer) modulePath() string {
+	if c.ModulePath == "" {
+		return "modules"
+	}
+	return c.ModulePath
+}
+
+// setup validates c and fills defaults.
+func (c Installer) setup() (Installer, error) {
+	c.ModulePath = c.modulePath()
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	c.Logger = orDiscard(c.Logger)
+	if c.ForgeURL == "" {
+		c.ForgeURL = defaultForgeURL
+	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = http.DefaultClient
+	}
+
+	p, err := dirtyPolicy(c.OnDirty, c.Force)
+	if err != nil {
+		return c, err
+	}
+	if c.Atomic && p == dirtyForce {
+		return c, fmt.Errorf("force needs on-dirty reset or backup with atomic install")
+	}
+	if c.Atomic && p == dirtyStash {
+		return c, fmt.Errorf("on-dirty %v cannot be used with atomic install", p)
+	}
+	if c.BranchStrategy, err = branchStrategy(c.BranchStrategy, false); err != nil {
+		return c, err
+	}
+	if c.rewriter, err = newRewriter(c.Rewrites); err != nil {
+		return c, err
+	}
+	c.runner = runner{timeout: c.Timeout, logger: c.Logger, observe: c.observer()}
+	c.limiter = newLimiter(c.HostLimits, c.Rate)
+	return c, nil
+}
+
+// observer returns a func calling OnGit and recording Metrics, or nil if neither is given.
+func (c Installer) observer() func(Invocation) {
+	if c.Metrics == nil {
+		return c.OnGit
+	}
+	return func(inv Invocation) {
+		c.Metrics.observeGit(inv)
+		if c.OnGit != nil {
+			c.OnGit(inv)
+		}
+	}
+}
+
+// installCmd is install and checkout commands.
 type installCmd struct {
-	throttle             int
-	forceCheckout        bool
-	onlyCheckout         bool
+	Installer
 	includesWithRepoName string
+	selector             Selector
+	purge                *purgeOpts
+	progress             io.Writer     // shows progress if given
+	tty                  bool          // redraws progress as a view
+	dryRun               bool          // prints a plan instead of install
+	planFile             string        // writes a plan in JSON for dryRun
+	apply                bool          // the file is a plan to be applied
+	summary              io.Writer     // prints a summary of time if given
+	trace                string        // writes git commands in Chrome trace-event format
+	wait                 time.Duration // waits for the lock of the module path
+	watch                bool          // installs again when the puppetfile changes
+	metricsFile          string        // writes Metrics for the textfile collector after each install
+	metricsAddr          string        // serves Metrics on /metrics while watching
 }
 
-func (c installCmd) Main(path string) {
+// Main installs modules in a puppetfile until SIGINT or SIGTERM is received.
+func (c installCmd) Main(path string) ([]Result, error) {
+	ctx, cancel := withSignals(context.Background())
+	defer cancel()
+	if c.watch {
+		return c.watchFile(ctx, path)
+	}
+	if c.apply {
+		return c.applyPlan(ctx, path)
+	}
 	r := newReader(path)
 	defer r.Close()
-	c.install(bufio.NewReader(r))
+	return c.install(ctx, bufio.NewReader(r))
 }
 
-func (c installCmd) install(src io.Reader) {
+// lock holds locks of directories which mods are installed into and
+// dirs unless dryRun, and returns a function to release them.
+func (c installCmd) lock(ctx context.Context, mods []Mod, dirs ...string) (func(), error) {
+	if c.dryRun {
+		return func() {}, nil
+	}
+	return lockDirs(ctx, modDirs(c.modulePath(), mods, dirs), c.wait)
+}
+
+// writeMetrics writes Metrics into metricsFile if it's given.
+func (c installCmd) writeMetrics() {
+	if c.metricsFile == "" || c.Metrics == nil {
+		return
+	}
+	if err := c.Metrics.WriteFile(c.metricsFile); err != nil {
+		log.Printf("[error] metrics: %v", err)
+	}
+}
+
+func (c installCmd) install(ctx context.Context, src io.Reader) ([]Result, error) {
 	ms, err := parsePuppetfile(src)
 	if err != nil {
-		log.Fatalf("%v\n", err)
+		return nil, err
+	}
+	unlock, err := c.lock(ctx, ms)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	defer c.writeMetrics()
+	return c.installMods(ctx, ms, ms)
+}
+
+// installMods installs selected ones of ms, and purges directories
+// which no mod in all declares.
+func (c installCmd) installMods(ctx context.Context, all, ms []Mod) ([]Result, error) {
+	mods, err := c.selectMods(ms)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.dryRun {
+		return nil, c.plan(ctx, mods, all)
+	}
+	results, err := c.installWithProgress(ctx, mods)
+	if err != nil {
+		return nil, err
 	}
+	if c.purge != nil && ctx.Err() == nil {
+		if err := purge(c.modulePath(), all, *c.purge); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
 
+// selectMods returns mods chosen by the selector and whose repository
+// matches includesWithRepoName. The URL of a forge module is looked up
+// if it's needed.
+func (c installCmd) selectMods(ms []Mod) ([]Mod, error) {
 	logger.Printf("includes-with-repository-name: '%v'", c.includesWithRepoName)
 	re, err := regexp.Compile(c.includesWithRepoName)
 	if err != nil {
-		log.Fatalf("%v\n", err)
+		return nil, err
+	}
+	i, err := c.setup()
+	if err != nil {
+		return nil, err
 	}
 	mods := make([]Mod, 0)
-	for _, e := range ms {
-		if y := re.Match([]byte(e.opts["git"])); y {
-			mods = append(mods, e)
+	for _, m := range c.selector.Select(ms) {
+		u := m.opts["git"]
+		if u == "" && c.includesWithRepoName != ".*" {
+			if u, err = i.giturl(m); err != nil {
+				// keep it to be reported as a failure by install
+				log.Printf("[warn] %v: %v", m.Fullname(), err)
+				mods = append(mods, m)
+				continue
+			}
+		}
+		if re.MatchString(u) {
+			mods = append(mods, m)
+		} else {
+			logger.Printf("skip: %v is not in %v", u, c.includesWithRepoName)
 		}
 	}
+	return mods, nil
+}
 
-	if c.throttle < 1 || len(mods) < c.throttle {
-		c.throttle = len(mods)
+// installWithProgress installs mods showing progress, and shows stats after that.
+func (c installCmd) installWithProgress(ctx context.Context, mods []Mod) ([]Result, error) {
+	var st *stats
+	if c.summary != nil || c.trace != "" {
+		st = newStats()
+		c.OnGit = st.Add
+	}
+	var p *progress
+	if c.progress != nil {
+		p = newProgress(c.progress, c.tty, len(mods))
+		c.OnEvent = p.Event
+		p.Start()
+	}
+	results, err := c.Install(ctx, mods)
+	if p != nil {
+		p.Stop()
+	}
+	if err != nil || st == nil {
+		return results, err
 	}
-	logger.Printf("mods size: %v, throttle: %v, timeout: %v", len(mods), c.throttle, timeout)
 
-	var wg sync.WaitGroup
-	wg.Add(len(mods))
+	st.finish()
+	if c.summary != nil {
+		st.printSummary(c.summary, c.Concurrency, results)
+	}
+	if c.trace != "" {
+		if err := st.writeTrace(c.trace, results); err != nil {
+			log.Printf("[error] trace: %v", err)
+		}
+	}
+	return results, nil
+}
 
-	tasks := make(chan Mod)
-	errs := make(chan Mod)
+// plan prints what install would do for mods, and what purge would do
+// considering all mods declared.
+func (c installCmd) plan(ctx context.Context, mods, all []Mod) error {
+	plan, err := c.Plan(ctx, mods)
+	if err != nil {
+		return err
+	}
+	if c.purge != nil {
+		ps, err := purgePlan(c.modulePath(), all, c.purge.keep)
+		if err != nil {
+			return err
+		}
+		plan = append(plan, ps...)
+	}
+	printPlan(os.Stdout, plan)
+	if c.planFile != "" {
+		return writePlanFile(c.planFile, plan)
+	}
+	return nil
+}
 
-	for i := 0; i < c.throttle; i++ {
-		go func() {
-			for m := range tasks {
-				defer wg.Done()
-				if err := c.installMod(m); err != nil {
-					m.err = err
-					errs <- m
-				}
-			}
-		}()
+// applyPlan installs mods at sha1 given in a plan and purges directories in it.
+func (c installCmd) applyPlan(ctx context.Context, path string) ([]Result, error) {
+	mods, purged, err := readPlanFile(path, c.modulePath())
+	if err != nil {
+		return nil, err
+	}
+	ds := make([]string, len(purged))
+	for i, p := range purged {
+		ds[i] = filepath.Dir(p)
 	}
+	unlock, err := c.lock(ctx, mods, ds...)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	defer c.writeMetrics()
+	mods = c.selector.Select(mods)
+	results, err := c.installWithProgress(ctx, mods)
+	if err != nil || ctx.Err() != nil {
+		return results, err
+	}
+	opts := purgeOpts{}
+	if c.purge != nil {
+		opts = *c.purge
+	}
+	return results, purgeDirs(purged, opts)
+}
 
-	failed := make([]Mod, 0)
-	go func() {
-		for e := range errs {
-			failed = append(failed, e)
+// installResult installs m and tells what was done.
+func (c Installer) installResult(ctx context.Context, m Mod) Result {
+	r := Result{OldSha1: gitHead(m.Dest()), Start: time.Now()}
+	ctx = withMod(ctx, m.name)
+	c.notify(Event{Mod: m, Phase: phaseStart})
+	err := c.installMod(ctx, &m)
+	r.Duration = time.Since(r.Start)
+	r.Mod, r.Op, r.Retries, r.Dirty, r.Err = m, m.cmd, m.retries, m.dirty, err
+	r.NewSha1 = gitHead(m.Dest())
+	r.Action = action(r)
+	if err == nil {
+		if err := c.postInstall(ctx, &m, r); err != nil {
+			r.Duration = time.Since(r.Start)
+			r.Mod, r.Op, r.Err = m, m.cmd, err
+			r.Action = action(r)
 		}
-	}()
+	}
+	c.notify(Event{Mod: m, Phase: phaseDone, Result: &r})
+	return r
+}
 
-	for _, m := range mods {
-		tasks <- m
+// action tells what was done for a mod by a result.
+func action(r Result) string {
+	err := r.Err
+	switch {
+	case err != nil && classify(err) == errCanceled:
+		return ActionCanceled
+	case err != nil:
+		return ActionFailed
+	case r.OldSha1 == "":
+		return ActionCloned
+	case r.OldSha1 == r.NewSha1:
+		return ActionUnchanged
+	default:
+		return ActionUpdated
 	}
-	close(tasks)
+}
+
+// Phases of Event other than git operations.
+const (
+	phaseStart = "start"
+	phaseDone  = "done"
+)
+
+// Event tells that a mod enters a phase, which is start, done or
+// an operation such as clone, fetch, checkout and pull.
+type Event struct {
+	Mod    Mod
+	Phase  string
+	Time   time.Time
+	Result *Result // given only for done
+}
+
+// phase records that m enters an operation and notifies it.
+func (c Installer) phase(m *Mod, op string) {
+	m.cmd = op
+	c.notify(Event{Mod: *m, Phase: op})
+}
 
-	wg.Wait()
-	close(errs)
+func (c Installer) notify(e Event) {
+	if c.OnEvent == nil {
+		return
+	}
+	e.Time = time.Now()
+	c.OnEvent(e)
+}
 
-	for _, m := range failed {
-		log.Printf("\t%v\t%v\t%v\n", m.err, m.cmd, m)
+// printResults prints modules which had local changes or failed.
+func printResults(results []Result) {
+	for _, r := range results {
+		if r.Dirty != "" {
+			log.Printf("[dirty] %v\t%v\t%v\n", r.Mod.name, r.Dirty, r.Mod.Dest())
+		}
 	}
-	if len(failed) > 0 {
-		os.Exit(1)
+	for _, r := range failedResults(results) {
+		log.Printf("[%v] %v\t%v\t%v\tretries:%v\t%v\t%v\n", r.Action, r.Mod.name, classify(r.Err), r.Op, r.Retries, r.Mod.opts["git"], r.Err)
 	}
 }
 
-func (c installCmd) installMod(m Mod) error {
-	if m.opts["git"] == "" {
-		m.opts["git"] = giturl(m)
-		if m.opts["git"] == "" {
-			log.Fatalf("[fatal] :git is empty %v", m)
+func (c Installer) installMod(ctx context.Context, m *Mod) error {
+	// a forge mod already installed is compared with its origin without the forge
+	forge := m.opts["git"] == "" && exists(m.Dest())
+	if forge {
+		m.opts["git"] = gitConfig(m.Dest(), "remote.origin.url")
+	}
+	if err := c.resolveURL(m); err != nil {
+		return err
+	}
+	if err := c.preInstall(ctx, m); err != nil {
+		return err
+	}
+
+	if exists(m.Dest()) && c.upToDate(ctx, m) {
+		m.cmd = "unchanged"
+		c.logger.Printf("unchanged: %v at %v", m.name, m.Ref())
+		return nil
+	}
+	if forge {
+		m.opts["git"] = ""
+		if err := c.resolveURL(m); err != nil {
+			return err
 		}
 	}
-	//logger.Printf("%v\n", m)
+	if c.Atomic {
+		return c.installModAtomic(ctx, m)
+	}
 
 	// start git operations
 	var err error
+	stashed := false
+	if exists(m.Dest()) {
+		stashed, err = c.handleDirty(ctx, m)
+		if err != nil {
+			return err
+		}
+	}
 	if !exists(m.Dest()) {
-		err = gitClone(m.opts["git"], m.Dest())
-		m.cmd = "clone"
+		err = c.clone(ctx, m, m.Dest())
 	} else {
-		err = gitSetUrl(m.Dest(), m.opts["git"])
+		err = c.fetch(ctx, m, m.Dest())
+	}
+	if err != nil {
+		return err
+	}
+	return c.checkout(ctx, m, m.Dest(), stashed)
+}
+
+// resolveURL sets :git of m from the forge if it's missing, and rewrites it.
+func (c Installer) resolveURL(m *Mod) error {
+	if m.opts["git"] == "" {
+		c.phase(m, "forge")
+		u, err := c.giturl(*m)
 		if err != nil {
 			return err
 		}
-
-		if !c.onlyCheckout {
-			err = gitFetch(m.Dest())
-			m.cmd = "fetch"
+		if u == "" {
+			return fmt.Errorf(":git is empty for %v", m.Fullname())
 		}
+		m.opts["git"] = u
 	}
-	if err != nil {
+	if u := c.rewriter.rewrite(m.opts["git"]); u != m.opts["git"] {
+		c.logger.Printf("rewrite: %v -> %v for %v", m.opts["git"], u, m.name)
+		m.opts["git"] = u
+	}
+	return nil
+}
+
+func (c Installer) clone(ctx context.Context, m *Mod, dir string) error {
+	c.phase(m, "clone")
+	return c.retry(ctx, m, func() error {
+		err := c.gitClone(ctx, m.opts["git"], dir)
+		if err != nil {
+			// remove a partial clone left by failure or cancellation
+			os.RemoveAll(dir)
+		}
 		return err
+	})
+}
+
+func (c Installer) fetch(ctx context.Context, m *Mod, dir string) error {
+	c.phase(m, "set-url")
+	if err := c.gitSetUrl(ctx, dir, m.opts["git"]); err != nil {
+		return err
+	}
+	if c.OnlyCheckout {
+		return nil
 	}
+	c.phase(m, "fetch")
+	return c.retry(ctx, m, func() error { return c.gitFetch(ctx, dir) })
+}
 
+// checkout checks out the ref of m in dir and pulls it if it's a branch.
+func (c Installer) checkout(ctx context.Context, m *Mod, dir string, stashed bool) error {
 	ver := m.version
 	if m.Ref() != "" {
 		ver = m.Ref()
 	}
 
-	err = gitCheckout(m.Dest(), ver, c.forceCheckout)
-	m.cmd = "checkout"
+	c.phase(m, "checkout")
+	err := c.gitCheckout(ctx, dir, ver, c.Force)
 	if err != nil {
 		return err
 	}
-	if !isTag(m.Dest(), ver) && !c.onlyCheckout {
-		err = gitPull(m.Dest(), ver)
-		m.cmd = "pull"
+	tag, err := isTag(dir, ver)
+	if err != nil {
+		return err
+	}
+	sha1, err := isSha1(dir, ver)
+	if err != nil {
+		return err
 	}
+	if !tag && !sha1 && !c.OnlyCheckout {
+		if c.BranchStrategy == branchReset {
+			var remote bool
+			if remote, err = isRef(dir, "remotes", "origin/"+ver); err == nil && remote {
+				c.phase(m, "reset")
+				err = c.gitResetTo(ctx, dir, "origin/"+ver)
+			}
+		} else {
+			c.phase(m, "pull")
+			err = c.retry(ctx, m, func() error { return c.gitPull(ctx, dir, ver) })
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if stashed {
+		c.phase(m, "stash pop")
+		if err := c.gitStashPop(ctx, dir); err != nil {
+			return err
+		}
+	}
+
+	if c.Submodules || m.Submodules() {
+		c.phase(m, "submodule")
+		err = c.gitSubmoduleUpdate(ctx, dir)
+	}
+	return err
+}
+
+// retry runs a network operation of git for m within the limits, adding retries to m.
+func (c Installer) retry(ctx context.Context, m *Mod, f func() error) error {
+	n, err := retry(ctx, c.logger, c.Retries, c.RetryWait, func() error {
+		return c.limiter.do(ctx, m.opts["git"], f)
+	})
+	m.retries += n
 	return err
 }
 
@@ -159,26 +591,41 @@ func exists(filename string) bool {
 	return err == nil
 }
 
-func giturl(m Mod) string {
-	ep := "https://forgeapi.puppetlabs.com/v3/modules/" + m.user + "-" + m.name
-	logger.Printf("%v", ep)
+const defaultForgeURL = "https://forgeapi.puppetlabs.com"
+
+// giturl returns the source of a mod in the Forge.
+func (c Installer) giturl(m Mod) (string, error) {
+	start := time.Now()
+	u, err := c.forgeSource(m)
+	if c.Metrics != nil {
+		c.Metrics.observeForge(time.Since(start), err)
+	}
+	return u, err
+}
+
+func (c Installer) forgeSource(m Mod) (string, error) {
+	ep := strings.TrimSuffix(c.ForgeURL, "/") + "/v3/modules/" + m.user + "-" + m.name
+	c.logger.Printf("%v", ep)
 	req, err := http.NewRequest("GET", ep, nil)
 	if err != nil {
-		log.Fatalf("%v", err)
+		return "", err
 	}
-	c := http.Client{}
-	res, err := c.Do(req)
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
 	if (res.StatusCode / 100) != 2 {
-		log.Fatalf("%v\t%v\t%v\n", m, ep, res)
+		return "", fmt.Errorf("%v for %v", res.Status, ep)
 	}
 
 	var v Res
 	b, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		log.Fatalf("%v", err)
+		return "", err
 	}
 	if err := json.Unmarshal(b, &v); err != nil {
-		log.Fatalf("%v", err)
+		return "", err
 	}
 
 	u := v.CurrentRelease.Metadata.Source
@@ -187,5 +634,5 @@ func giturl(m Mod) string {
 	}
 	// NOTE: workaround because 301 comes via http for github.com
 	//       and it's hard to handle it.
-	return regexp.MustCompile(`^http://`).ReplaceAllString(u, "https://")
+	return regexp.MustCompile(`^http://`).ReplaceAllString(u, "https://"), nil
 }
@@ -0,0 +1,100 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallResult(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	mdir := dir
+
+	up := filepath.Join(dir, "upstream")
+	c := testInstaller(t, Installer{BranchStrategy: branchReset})
+	m := Mod{name: "foo", moddir: mdir, opts: ModOpts{"git": up, "ref": "master"}}
+
+	r := c.installResult(ctx, m)
+	assert.Nil(t, r.Err)
+	assert.Equal(t, ActionCloned, r.Action)
+	assert.Equal(t, "", r.OldSha1)
+	assert.Equal(t, gitSha1(up, "master"), r.NewSha1)
+
+	r = c.installResult(ctx, m)
+	assert.Equal(t, ActionUnchanged, r.Action)
+
+	cmd := exec.Command("sh", "-c", "echo d >> README && git commit -qam d")
+	cmd.Dir = up
+	assert.Nil(t, cmd.Run())
+	r = c.installResult(ctx, m)
+	assert.Equal(t, ActionUpdated, r.Action)
+	assert.NotEqual(t, r.OldSha1, r.NewSha1)
+	assert.Equal(t, gitSha1(up, "master"), r.NewSha1)
+
+	r = c.installResult(ctx, Mod{name: "foo", moddir: mdir, opts: ModOpts{"git": up, "ref": "no-such-ref"}})
+	assert.Equal(t, ActionFailed, r.Action)
+	assert.Equal(t, "checkout", r.Op)
+	assert.Equal(t, errRefNotFound, classify(r.Err))
+}
+
+// testInstaller returns c ready to install mods without Install.
+func testInstaller(t *testing.T, c Installer) Installer {
+	c, err := c.setup()
+	assert.Nil(t, err)
+	return c
+}
+
+func TestInstallConcurrently(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	up := filepath.Join(dir, "upstream")
+
+	pf, err := ParsePuppetfile(strings.NewReader("mod 'foo', :git => '"+up+"', :ref => 'develop'\n"), ParseOptions{})
+	assert.Nil(t, err)
+
+	paths := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+	var wg sync.WaitGroup
+	for _, p := range paths {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			results, err := Installer{ModulePath: p, BranchStrategy: branchReset}.Install(context.Background(), pf.Mods)
+			assert.Nil(t, err)
+			assert.Equal(t, ActionCloned, results[0].Action)
+			assert.Equal(t, filepath.Join(p, "foo"), results[0].Mod.Dest())
+		}(p)
+	}
+	wg.Wait()
+	for _, p := range paths {
+		assert.Equal(t, gitSha1(up, "develop"), gitSha1(filepath.Join(p, "foo"), "HEAD"))
+	}
+	assert.Equal(t, up, pf.Mods[0].Git())
+
+	_, err = Installer{OnDirty: "unknown"}.Install(context.Background(), pf.Mods)
+	assert.NotNil(t, err)
+}
+
+func TestSelectMods(t *testing.T) {
+	mods, _ := parsePuppetfile(r(`
+mod 'foo', :git => 'https://github.com/a/foo.git', :tags => ['web']
+mod 'bar', :git => 'https://example.com/a/bar.git', :tags => ['web']
+mod 'baz', :git => 'https://github.com/a/baz.git'
+`))
+	c := installCmd{includesWithRepoName: "github.com", selector: Selector{Tags: []string{"web"}}}
+	ms, err := c.selectMods(mods)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ms))
+	assert.Equal(t, "foo", ms[0].name)
+
+	c.includesWithRepoName = "("
+	_, err = c.selectMods(mods)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,142 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// lockFile is created in the module path, and hidden from purge.
+const lockFile = ".librarian-puppet-go.lock"
+
+const lockInterval = 100 * time.Millisecond
+
+// LockedError tells that another process holds the lock.
+type LockedError struct {
+	Path string
+	PID  int // 0 if unknown
+}
+
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("%v is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("%v is locked by pid %v", e.Path, e.PID)
+}
+
+// dirLock is an exclusive lock on a directory.
+type dirLock struct {
+	f *os.File
+}
+
+// lockDir takes an exclusive lock on dir waiting for wait at most,
+// and writes the pid into the lock file. The lock is released when
+// the process exits even if unlock isn't called.
+func lockDir(ctx context.Context, dir string, wait time.Duration) (*dirLock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	p := filepath.Join(dir, lockFile)
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, &LockedError{Path: p, PID: lockPID(p)}
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockInterval):
+		}
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &dirLock{f: f}, nil
+}
+
+// lockDirs takes locks on dirs in order, and returns a function to release them.
+func lockDirs(ctx context.Context, dirs []string, wait time.Duration) (func(), error) {
+	ls := make([]*dirLock, 0, len(dirs))
+	unlock := func() {
+		for i := len(ls) - 1; i >= 0; i-- {
+			ls[i].unlock()
+		}
+	}
+	for _, d := range dirs {
+		l, err := lockDir(ctx, d, wait)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		ls = append(ls, l)
+	}
+	return unlock, nil
+}
+
+// modDirs returns directories which mods are installed into and dirs,
+// sorted so that processes take locks in the same order. It's modpath if
+// there is none, where purge removes all directories.
+func modDirs(modpath string, mods []Mod, dirs []string) []string {
+	seen := map[string]bool{}
+	ds := make([]string, 0)
+	add := func(d string) {
+		if d = filepath.Clean(d); !seen[d] {
+			seen[d] = true
+			ds = append(ds, d)
+		}
+	}
+	for _, m := range mods {
+		m.moddir = modpath
+		add(m.dir())
+	}
+	for _, d := range dirs {
+		add(d)
+	}
+	if len(ds) == 0 {
+		add(modpath)
+	}
+	sort.Strings(ds)
+	return ds
+}
+
+// lockPID returns the pid written in a lock file, or 0.
+func lockPID(p string) int {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+	return pid
+}
+
+// unlock releases the lock. The lock file is left to be reused.
+func (l *dirLock) unlock() error {
+	l.f.Truncate(0)
+	return l.f.Close()
+}
@@ -0,0 +1,77 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockDir(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lpg-test")
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	mdir := filepath.Join(dir, "modules")
+
+	l, err := lockDir(ctx, mdir, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, os.Getpid(), lockPID(filepath.Join(mdir, lockFile)))
+
+	_, err = lockDir(ctx, mdir, 0)
+	if assert.IsType(t, &LockedError{}, err) {
+		assert.Equal(t, os.Getpid(), err.(*LockedError).PID)
+		assert.Contains(t, err.Error(), "is locked by pid")
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = lockDir(cctx, mdir, time.Minute)
+	assert.Equal(t, context.Canceled, err)
+
+	go func(l *dirLock) {
+		time.Sleep(3 * lockInterval)
+		l.unlock()
+	}(l)
+	l2, err := lockDir(ctx, mdir, time.Minute)
+	assert.Nil(t, err)
+	assert.Nil(t, l2.unlock())
+
+	ds, err := unmanaged(mdir, nil, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, ds)
+}
+
+func TestLockDirs(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lpg-test")
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	mdir, vendor, site := filepath.Join(dir, "modules"), filepath.Join(dir, "vendor"), filepath.Join(dir, "site")
+
+	mods := []Mod{{name: "foo", moduledir: vendor}, {name: "bar", moduledir: vendor, installPath: site}}
+	ds := modDirs(mdir, mods, nil)
+	assert.Equal(t, []string{site, vendor}, ds)
+	assert.Equal(t, []string{mdir}, modDirs(mdir, nil, nil))
+	assert.Equal(t, []string{mdir, vendor}, modDirs(mdir, mods[:1], []string{mdir}))
+
+	unlock, err := lockDirs(ctx, ds, 0)
+	assert.Nil(t, err)
+	assert.False(t, exists(mdir))
+	_, err = lockDir(ctx, vendor, 0)
+	assert.IsType(t, &LockedError{}, err)
+
+	// locks taken before a failure are released
+	_, err = lockDirs(ctx, []string{mdir, vendor}, 0)
+	assert.NotNil(t, err)
+	l, err := lockDir(ctx, mdir, 0)
+	assert.Nil(t, err)
+	l.unlock()
+
+	unlock()
+	l, err = lockDir(ctx, vendor, 0)
+	assert.Nil(t, err)
+	l.unlock()
+}
@@ -0,0 +1,272 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Buckets of histograms in seconds.
+var (
+	gitBuckets   = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+	forgeBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+const metricLastSuccess = "librarian_puppet_last_success_timestamp_seconds"
+
+// Metrics collects counters and histograms of installs, and writes them
+// in the text format of Prometheus. It's safe for concurrent use.
+type Metrics struct {
+	mu          sync.Mutex
+	modules     map[[2]string]float64 // by module path and action
+	git         map[string]*histogram // by operation
+	gitErrors   map[string]float64    // by operation
+	forge       *histogram
+	forgeErrors float64
+	lastSuccess map[string]float64 // unix time by module path
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		modules:     map[[2]string]float64{},
+		git:         map[string]*histogram{},
+		gitErrors:   map[string]float64{},
+		forge:       newHistogram(forgeBuckets),
+		lastSuccess: map[string]float64{},
+	}
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []float64 // cumulative for each bucket
+	sum     float64
+	count   float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]float64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// observeGit records a git command. Hooks run by sh are not git operations.
+func (m *Metrics) observeGit(inv Invocation) {
+	if inv.Op == "sh" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.git[inv.Op]
+	if !ok {
+		h = newHistogram(gitBuckets)
+		m.git[inv.Op] = h
+	}
+	h.observe(inv.Duration.Seconds())
+	if inv.Err != nil {
+		m.gitErrors[inv.Op]++
+	}
+}
+
+// observeForge records a request to the Forge API.
+func (m *Metrics) observeForge(d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forge.observe(d.Seconds())
+	if err != nil {
+		m.forgeErrors++
+	}
+}
+
+// observeInstall records results of mods installed into a module path.
+// The module path succeeds if no mod fails nor is canceled.
+func (m *Metrics) observeInstall(path string, results []Result, t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ok := true
+	for _, r := range results {
+		m.modules[[2]string{path, r.Action}]++
+		if r.Err != nil {
+			ok = false
+		}
+	}
+	if ok {
+		m.lastSuccess[path] = float64(t.Unix())
+	}
+}
+
+// ServeHTTP serves metrics for /metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// serveMetrics serves m on /metrics of addr until ctx is done.
+func serveMetrics(ctx context.Context, addr string, m *Metrics) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// WriteTo writes metrics in the text format with labels in a stable order.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := &strings.Builder{}
+
+	header(b, "librarian_puppet_modules_total", "counter", "Modules installed by module path and action.")
+	keys := make([][2]string, 0, len(m.modules))
+	for k := range m.modules {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] == keys[j][0] {
+			return keys[i][1] < keys[j][1]
+		}
+		return keys[i][0] < keys[j][0]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(b, "librarian_puppet_modules_total{module_path=%v,action=%v} %v\n", quoteLabel(k[0]), quoteLabel(k[1]), formatValue(m.modules[k]))
+	}
+
+	header(b, "librarian_puppet_git_duration_seconds", "histogram", "Duration of git commands by operation.")
+	for _, op := range sortedKeys(m.git) {
+		writeHistogram(b, "librarian_puppet_git_duration_seconds", "op="+quoteLabel(op)+",", m.git[op])
+	}
+	header(b, "librarian_puppet_git_errors_total", "counter", "Failed git commands by operation.")
+	for _, op := range sortedKeys(m.git) {
+		fmt.Fprintf(b, "librarian_puppet_git_errors_total{op=%v} %v\n", quoteLabel(op), formatValue(m.gitErrors[op]))
+	}
+
+	header(b, "librarian_puppet_forge_request_duration_seconds", "histogram", "Duration of requests to the Forge API.")
+	writeHistogram(b, "librarian_puppet_forge_request_duration_seconds", "", m.forge)
+	header(b, "librarian_puppet_forge_errors_total", "counter", "Failed requests to the Forge API.")
+	fmt.Fprintf(b, "librarian_puppet_forge_errors_total %v\n", formatValue(m.forgeErrors))
+
+	header(b, metricLastSuccess, "gauge", "Time of the last install in which no module failed, by module path.")
+	for _, p := range sortedKeys(m.lastSuccess) {
+		fmt.Fprintf(b, "%v{module_path=%v} %v\n", metricLastSuccess, quoteLabel(p), formatValue(m.lastSuccess[p]))
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func header(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, typ)
+}
+
+// writeHistogram writes buckets, sum and count. labels ends with a comma if given.
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%v_bucket{%vle=\"%v\"} %v\n", name, labels, formatValue(b), formatValue(h.counts[i]))
+	}
+	fmt.Fprintf(w, "%v_bucket{%vle=\"+Inf\"} %v\n", name, labels, formatValue(h.count))
+	l := strings.TrimSuffix(labels, ",")
+	if l != "" {
+		l = "{" + l + "}"
+	}
+	fmt.Fprintf(w, "%v_sum%v %v\n", name, l, formatValue(h.sum))
+	fmt.Fprintf(w, "%v_count%v %v\n", name, l, formatValue(h.count))
+}
+
+func sortedKeys(m interface{}) []string {
+	ks := make([]string, 0)
+	switch v := m.(type) {
+	case map[string]*histogram:
+		for k := range v {
+			ks = append(ks, k)
+		}
+	case map[string]float64:
+		for k := range v {
+			ks = append(ks, k)
+		}
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+func quoteLabel(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+func unquoteLabel(s string) string {
+	r := strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\"`, `"`)
+	return r.Replace(s)
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+var lastSuccessLine = regexp.MustCompile(`^` + metricLastSuccess + `\{module_path="((?:[^"\\]|\\.)*)"\} (\S+)$`)
+
+// WriteFile writes metrics to path for the textfile collector of node_exporter.
+// The last success of a module path not installed in this run is kept from
+// the file. It's replaced with a rename so that a half-written file is never read.
+func (m *Metrics) WriteFile(path string) error {
+	if b, err := ioutil.ReadFile(path); err == nil {
+		m.mu.Lock()
+		for _, l := range strings.Split(string(b), "\n") {
+			s := lastSuccessLine.FindStringSubmatch(l)
+			if s == nil {
+				continue
+			}
+			p := unquoteLabel(s[1])
+			if v, err := strconv.ParseFloat(s[2], 64); err == nil && m.lastSuccess[p] == 0 {
+				m.lastSuccess[p] = v
+			}
+		}
+		m.mu.Unlock()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := m.WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
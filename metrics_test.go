@@ -0,0 +1,97 @@
+package librarianpuppetgo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsWriteTo(t *testing.T) {
+	m := NewMetrics()
+	m.observeGit(Invocation{Op: "fetch", Duration: 200 * time.Millisecond})
+	m.observeGit(Invocation{Op: "fetch", Duration: 3 * time.Second, Err: errors.New("exit status 128")})
+	m.observeGit(Invocation{Op: "sh", Duration: time.Second})
+	m.observeForge(70*time.Millisecond, nil)
+	m.observeForge(time.Second, errors.New("404 Not Found"))
+	m.observeInstall("modules", []Result{{Action: ActionCloned}, {Action: ActionUnchanged}}, time.Unix(1500000000, 0))
+	m.observeInstall(`a"b`, []Result{{Action: ActionFailed, Err: errors.New("x")}}, time.Unix(1500000001, 0))
+
+	b := &bytes.Buffer{}
+	_, err := m.WriteTo(b)
+	assert.Nil(t, err)
+	s := b.String()
+	for _, l := range []string{
+		`librarian_puppet_modules_total{module_path="a\"b",action="failed"} 1`,
+		`librarian_puppet_modules_total{module_path="modules",action="cloned"} 1`,
+		`librarian_puppet_git_duration_seconds_bucket{op="fetch",le="0.25"} 1`,
+		`librarian_puppet_git_duration_seconds_bucket{op="fetch",le="5"} 2`,
+		`librarian_puppet_git_duration_seconds_bucket{op="fetch",le="+Inf"} 2`,
+		`librarian_puppet_git_duration_seconds_sum{op="fetch"} 3.2`,
+		`librarian_puppet_git_duration_seconds_count{op="fetch"} 2`,
+		`librarian_puppet_git_errors_total{op="fetch"} 1`,
+		`librarian_puppet_forge_request_duration_seconds_bucket{le="0.1"} 1`,
+		`librarian_puppet_forge_request_duration_seconds_count 2`,
+		`librarian_puppet_forge_errors_total 1`,
+		`librarian_puppet_last_success_timestamp_seconds{module_path="modules"} 1500000000`,
+		"# TYPE librarian_puppet_git_duration_seconds histogram",
+	} {
+		assert.Contains(t, s, l+"\n")
+	}
+	assert.NotContains(t, s, `op="sh"`)
+	assert.NotContains(t, s, `last_success_timestamp_seconds{module_path="a\"b"}`)
+	assert.True(t, strings.Index(s, `module_path="a\"b"`) < strings.Index(s, `module_path="modules"`))
+}
+
+func TestMetricsWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "lp.prom")
+
+	m := NewMetrics()
+	m.observeInstall("a", nil, time.Unix(100, 0))
+	m.observeInstall("b", nil, time.Unix(100, 0))
+	assert.Nil(t, m.WriteFile(p))
+
+	// b fails, so the last success of it is kept from the file
+	m = NewMetrics()
+	m.observeInstall("a", nil, time.Unix(200, 0))
+	m.observeInstall("b", []Result{{Action: ActionFailed, Err: errors.New("x")}}, time.Unix(200, 0))
+	assert.Nil(t, m.WriteFile(p))
+
+	b, err := ioutil.ReadFile(p)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `librarian_puppet_last_success_timestamp_seconds{module_path="a"} 200`+"\n")
+	assert.Contains(t, string(b), `librarian_puppet_last_success_timestamp_seconds{module_path="b"} 100`+"\n")
+	fs, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 1, len(fs))
+}
+
+func TestMetricsInstall(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	up := filepath.Join(dir, "upstream")
+
+	var invs []Invocation
+	m := NewMetrics()
+	c := Installer{ModulePath: filepath.Join(dir, "modules"), Metrics: m, OnGit: func(inv Invocation) { invs = append(invs, inv) }}
+	_, err := c.Install(context.Background(), []Mod{{name: "foo", opts: ModOpts{"git": up, "ref": "master"}}})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, invs)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	s := rec.Body.String()
+	assert.Contains(t, s, `librarian_puppet_modules_total{module_path="`+c.ModulePath+`",action="cloned"} 1`)
+	assert.Contains(t, s, `librarian_puppet_git_duration_seconds_count{op="clone"} 1`)
+	assert.Contains(t, s, `librarian_puppet_last_success_timestamp_seconds{module_path="`+c.ModulePath+`"}`)
+}
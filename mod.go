@@ -3,6 +3,8 @@ package librarianpuppetgo
 import (
 	"fmt"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
 type ModOpts map[string]string
@@ -15,7 +17,46 @@ type Mod struct {
 	version string  // 4.1.0
 	opts    ModOpts // git => git@github.com:foo/bar.git, ref => v0.4.1
 	cmd     string  // clone, fetch, checkout
-	err     error
+	retries int
+	dirty   string // what was done for local changes
+	moddir  string // directory where it's installed, "modules" if empty
+
+	installPath string // given by :install_path, prior to moduledir
+	moduledir   string // given by moduledir directive, prior to moddir
+}
+
+// Name returns the module name, e.g. stdlib.
+func (m Mod) Name() string {
+	return m.name
+}
+
+// User returns the owner of a forge module, e.g. puppetlabs.
+func (m Mod) User() string {
+	return m.user
+}
+
+// Version returns the version of a forge module, e.g. 4.1.0.
+func (m Mod) Version() string {
+	return m.version
+}
+
+// Git returns the URL given by :git.
+func (m Mod) Git() string {
+	return m.opts["git"]
+}
+
+// Opt returns the value of an option, e.g. Opt("ref") for :ref.
+func (m Mod) Opt(key string) string {
+	return m.opts[key]
+}
+
+// Opts returns a copy of options.
+func (m Mod) Opts() map[string]string {
+	o := make(map[string]string, len(m.opts))
+	for k, v := range m.opts {
+		o[k] = v
+	}
+	return o
 }
 
 func (m Mod) Fullname() string {
@@ -29,8 +70,19 @@ func (m Mod) String() string {
 	return fmt.Sprintf("name:%v\topts:%v\tuser:%v\tversion:%v", m.name, m.opts, m.user, m.version)
 }
 
+// Dest returns the directory where m is installed.
 func (m Mod) Dest() string {
-	return filepath.Join(modulePath, m.name)
+	return filepath.Join(m.dir(), m.name)
+}
+
+// dir returns :install_path, moduledir or the module path in this order.
+func (m Mod) dir() string {
+	for _, d := range []string{m.installPath, m.moduledir, m.moddir} {
+		if d != "" {
+			return d
+		}
+	}
+	return "modules"
 }
 
 func (m *Mod) Replace(e *Mod) {
@@ -39,21 +91,51 @@ func (m *Mod) Replace(e *Mod) {
 	for k, v := range e.opts {
 		m.opts[k] = v
 	}
+	if e.installPath != "" {
+		m.installPath = e.installPath
+	}
+	if e.moduledir != "" {
+		m.moduledir = e.moduledir
+	}
 }
 
 func (m Mod) Format() string {
 	if m.opts["git"] == "" && m.opts["ref"] == "" {
 		if m.user != "" {
 			if m.version != "" {
-				return fmt.Sprintf("mod '%s/%s', '%s'", m.user, m.name, m.version)
+				return fmt.Sprintf("mod '%s/%s', '%s'", m.user, m.name, m.version) + m.formatExtraOpts()
 			} else {
-				return fmt.Sprintf("mod '%s/%s'", m.user, m.name)
+				return fmt.Sprintf("mod '%s/%s'", m.user, m.name) + m.formatExtraOpts()
 			}
 		} else {
-			return fmt.Sprintf("mod '%s', '%s'", m.name, m.version)
+			return fmt.Sprintf("mod '%s', '%s'", m.name, m.version) + m.formatExtraOpts()
+		}
+	}
+	s := fmt.Sprintf("mod '%s', :git => '%s', :ref => '%s'", m.name, m.opts["git"], m.Ref())
+	return s + m.formatExtraOpts()
+}
+
+// formatExtraOpts formats options other than :git and :ref in name order.
+func (m Mod) formatExtraOpts() string {
+	keys := make([]string, 0)
+	for k := range m.opts {
+		if k != "git" && k != "ref" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	s := ""
+	for _, k := range keys {
+		v := m.opts[k]
+		if k == "tags" {
+			s += fmt.Sprintf(", :%s => ['%s']", k, strings.Join(m.Tags(), "', '"))
+		} else if v == "true" || v == "false" {
+			s += fmt.Sprintf(", :%s => %s", k, v)
+		} else {
+			s += fmt.Sprintf(", :%s => '%s'", k, v)
 		}
 	}
-	return fmt.Sprintf("mod '%s', :git => '%s', :ref => '%s'", m.name, m.opts["git"], m.Ref())
+	return s
 }
 
 func (m Mod) Ref() string {
@@ -63,6 +145,19 @@ func (m Mod) Ref() string {
 	return m.opts["ref"]
 }
 
+// Tags returns names given by :tags => [...].
+func (m Mod) Tags() []string {
+	if m.opts["tags"] == "" {
+		return []string{}
+	}
+	return strings.Split(m.opts["tags"], ",")
+}
+
+// Submodules reports whether :submodules => true is given.
+func (m Mod) Submodules() bool {
+	return m.opts["submodules"] == "true"
+}
+
 func (m Mod) RefSemver() string {
 	x, y, z, err := semanticVersion(m.Ref())
 	if err != nil {
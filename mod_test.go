@@ -27,6 +27,9 @@ func TestModFormat(t *testing.T) {
 
 	mods, _ = parsePuppetfile(r(`mod 'foobar/brabra'`))
 	assert.Equal(t, "mod 'foobar/brabra'", mods[0].Format())
+
+	mods, _ = parsePuppetfile(r(`mod 'foo', :submodules => true, :git => 'a@b.com', :ref => 'v1.0.0'`))
+	assert.Equal(t, "mod 'foo', :git => 'a@b.com', :ref => 'v1.0.0', :submodules => true", mods[0].Format())
 }
 
 func TestModRef(t *testing.T) {
@@ -58,3 +61,20 @@ func TestModRefSemver(t *testing.T) {
 		assert.Equal(t, e.want, mods[0].RefSemver())
 	}
 }
+
+func TestModTags(t *testing.T) {
+	mods, _ := parsePuppetfile(r(`mod 'foo', :git => 'a@b.com', :ref => 'v1.0.0', :tags => ['web', "db"]`))
+	assert.Equal(t, []string{"web", "db"}, mods[0].Tags())
+	assert.Equal(t, "v1.0.0", mods[0].Ref())
+	assert.Equal(t, `mod 'foo', :git => 'a@b.com', :ref => 'v1.0.0', :tags => ['web', 'db']`, mods[0].Format())
+
+	mods, _ = parsePuppetfile(r(`mod 'puppetlabs/stdlib', '4.1.0', :tags => ['base']`))
+	assert.Equal(t, "stdlib", mods[0].name)
+	assert.Equal(t, "puppetlabs", mods[0].user)
+	assert.Equal(t, "4.1.0", mods[0].version)
+	assert.Equal(t, []string{"base"}, mods[0].Tags())
+	assert.Equal(t, `mod 'puppetlabs/stdlib', '4.1.0', :tags => ['base']`, mods[0].Format())
+
+	mods, _ = parsePuppetfile(r(`mod 'bar', :git => 'a@b.com'`))
+	assert.Equal(t, []string{}, mods[0].Tags())
+}
@@ -4,14 +4,63 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"log"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 )
 
+// ParseOptions tells how to parse a puppetfile.
+type ParseOptions struct {
+	Dir    string      // directory to resolve relative includes, :install_path and moduledir, the current one if empty
+	Logger *log.Logger // discarded if nil
+}
+
+// Puppetfile has mods declared in a puppetfile after merging includes.
+type Puppetfile struct {
+	Mods []Mod
+}
+
+// ParsePuppetfile parses a puppetfile read from r.
+func ParsePuppetfile(r io.Reader, opts ParseOptions) (*Puppetfile, error) {
+	p := parser{
+		open: func(n string) (io.ReadCloser, error) {
+			if !filepath.IsAbs(n) {
+				n = filepath.Join(opts.Dir, n)
+			}
+			return os.Open(n)
+		},
+		dir:    opts.Dir,
+		logger: orDiscard(opts.Logger),
+	}
+	mods, err := p.parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Puppetfile{Mods: mods}, nil
+}
+
+// parsePuppetfile parses with newReader for includes, which is used by commands.
 func parsePuppetfile(i io.Reader) ([]Mod, error) {
+	p := parser{
+		open:   func(n string) (io.ReadCloser, error) { return newReader(n), nil },
+		logger: logger,
+	}
+	return p.parse(i)
+}
+
+type parser struct {
+	open   func(string) (io.ReadCloser, error) // opens an included file
+	dir    string                              // resolves relative :install_path and moduledir
+	logger *log.Logger
+}
+
+func (p parser) parse(i io.Reader) ([]Mod, error) {
 	r := bufio.NewReader(i)
 	incs := make([][]Mod, 0)
 	mods := make([]Mod, 0)
+	moduledir := ""
 	for {
 		b, _, err := r.ReadLine()
 		if err == io.EOF {
@@ -26,11 +75,14 @@ func parsePuppetfile(i io.Reader) ([]Mod, error) {
 			if len(mods) > 0 {
 				return mods, fmt.Errorf("[error] include(s) must be at the top: %v", s)
 			}
-			logger.Printf("include: '%v'\n", a)
+			p.logger.Printf("include: '%v'\n", a)
 
-			r := newReader(a)
+			r, err := p.open(a)
+			if err != nil {
+				return mods, err
+			}
 			defer r.Close()
-			inc, err := parsePuppetfile(bufio.NewReader(r))
+			inc, err := p.parse(r)
 			if err != nil {
 				return mods, err
 			}
@@ -38,18 +90,40 @@ func parsePuppetfile(i io.Reader) ([]Mod, error) {
 			continue
 		}
 
+		if d := isModuledir(s); d != "" {
+			moduledir = p.path(d)
+			continue
+		}
+
 		m, err := parseMod(s)
 		if err != nil {
 			if _, ok := (err).(Ignorable); ok {
 				continue
 			}
-			logger.Printf("[warn] %v\n", err)
+			p.logger.Printf("[warn] %v\n", err)
 			return mods, err
 		}
+		if d := m.opts["install_path"]; d != "" {
+			m.installPath = p.path(d)
+		}
 		mods = append(mods, m)
 	}
 
-	return packMods(&incs, &mods), nil
+	all := packMods(&incs, &mods)
+	for i := range all {
+		if all[i].moduledir == "" {
+			all[i].moduledir = moduledir
+		}
+	}
+	return all, nil
+}
+
+// path resolves a relative path in the same way as includes.
+func (p parser) path(d string) string {
+	if filepath.IsAbs(d) {
+		return d
+	}
+	return filepath.Join(p.dir, d)
 }
 
 func parseMod(i string) (Mod, error) {
@@ -60,18 +134,16 @@ func parseMod(i string) (Mod, error) {
 		return Mod{}, Ignorable{fmt.Errorf("'%v' '/'", s)}
 	}
 
-	re = regexp.MustCompile(`^mod\s+["']([a-z/_0-9]+)['"]\s*(,\s*["'](\d+\.\d+(\.\d+)?)["'])?$`).FindAllStringSubmatch(s, -1)
-	if len(re) > 0 {
+	// a forge module may have options other than :git, e.g. :tags
+	re = regexp.MustCompile(`^mod\s+["']([a-z/_0-9]+)['"]\s*(,\s*["'](\d+\.\d+(\.\d+)?)["'])?\s*(,(\s*:.*))?$`).FindAllStringSubmatch(s, -1)
+	if len(re) > 0 && (re[0][6] == "" || strings.Contains(re[0][1], "/") && !strings.Contains(re[0][6], ":git")) {
 		n := re[0][1]
-		v := ""
-		if len(re[0]) > 3 {
-			v = re[0][3]
-		}
+		v := re[0][3]
 		nn := strings.Split(n, "/")
 		if len(nn) != 2 {
 			return Mod{}, fmt.Errorf("'%v' should contain one '/'", n)
 		}
-		return Mod{name: nn[1], user: nn[0], version: v, opts: ModOpts{}}, nil
+		return Mod{name: nn[1], user: nn[0], version: v, opts: parseOpts(re[0][6])}, nil
 	}
 
 	re = regexp.MustCompile(`^mod\s+([^,]+),(.*?)$`).FindAllStringSubmatch(s, -1)
@@ -115,24 +187,75 @@ func isInclude(s string) string /* filename */ {
 	return re[0][1]
 }
 
+// isModuledir returns a directory given by moduledir 'path'.
+func isModuledir(s string) string {
+	re := regexp.MustCompile(`^moduledir\s+["'](.*?)["']`).FindAllStringSubmatch(s, -1)
+	if len(re) == 0 {
+		return ""
+	}
+	return re[0][1]
+}
+
 // Marker to ignore intentionally
 type Ignorable struct {
 	error
 }
 
+// unquote removes a pair of quotes around s. Quotes in it are kept,
+// e.g. 'find . -name "*.pyc"' for a hook.
 func unquote(s string) string {
-	return regexp.MustCompile(`["']`).ReplaceAllString(s, "")
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
 }
 
+// parseOpts parses options. Elements of an array such as :tags => ['a', 'b']
+// are joined with comma.
 func parseOpts(s string) ModOpts {
 	m := make(ModOpts)
-	for _, e := range strings.Split(s, ",") {
+	for _, e := range splitOpts(s) {
 		re := regexp.MustCompile(`:([a-z_]+)\s*=>\s*(.*)$`).FindAllStringSubmatch(e, -1)
 		//fmt.Printf("%v", re)
 		if len(re) == 0 {
 			continue
 		}
-		m[re[0][1]] = unquote(re[0][2])
+		v := strings.TrimSpace(re[0][2])
+		if strings.HasPrefix(v, "[") && strings.HasSuffix(v, "]") {
+			es := make([]string, 0)
+			for _, a := range strings.Split(v[1:len(v)-1], ",") {
+				if a = strings.TrimSpace(unquote(a)); a != "" {
+					es = append(es, a)
+				}
+			}
+			v = strings.Join(es, ",")
+		}
+		m[re[0][1]] = unquote(v)
 	}
 	return m
 }
+
+// splitOpts splits s with commas out of quotes and brackets.
+func splitOpts(s string) []string {
+	es := make([]string, 0)
+	depth, quote, start := 0, rune(0), 0
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			es = append(es, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(es, s[start:])
+}
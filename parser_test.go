@@ -3,6 +3,8 @@ package librarianpuppetgo
 import (
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -229,6 +231,70 @@ func TestParseMod(t *testing.T) {
 	}
 }
 
+func TestParsePuppetfileWithOptions(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lpg-test")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "Puppetfile.common"), []byte("mod 'foo', :git => 'a@b.com', :ref => 'v0.1.0'\n"), 0644)
+
+	pf, err := ParsePuppetfile(strings.NewReader("include 'Puppetfile.common'\nmod 'foo', :ref => 'v0.2.0'\n"), ParseOptions{Dir: dir})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(pf.Mods) != 1 || pf.Mods[0].Name() != "foo" || pf.Mods[0].Git() != "a@b.com" || pf.Mods[0].Ref() != "v0.2.0" {
+		t.Errorf("unexpected mods: %v", pf.Mods)
+	}
+
+	_, err = ParsePuppetfile(strings.NewReader("include 'Puppetfile.missing'\n"), ParseOptions{Dir: dir})
+	if err == nil {
+		t.Errorf("should be error for a missing include")
+	}
+}
+
+func TestParseInstallPath(t *testing.T) {
+	pf, err := ParsePuppetfile(strings.NewReader(`
+moduledir 'vendor'
+mod 'foo', :git => 'a@b.com', :ref => 'v0.1.0'
+mod 'roles', :git => 'a@b.com', :install_path => 'site'
+mod 'puppetlabs/stdlib', '4.1.0', :install_path => '/opt/puppet'
+`), ParseOptions{Dir: "env"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for i, e := range []string{"env/vendor/foo", "env/site/roles", "/opt/puppet/stdlib"} {
+		if d := pf.Mods[i].Dest(); d != e {
+			t.Errorf("'%v' should be '%v'", d, e)
+		}
+	}
+}
+
+func TestParseOptsQuotes(t *testing.T) {
+	for s, e := range map[string]string{
+		`:post_install => 'find . -name "*.pyc" -delete'`: `find . -name "*.pyc" -delete`,
+		`:post_install => "echo it's"`:                    `echo it's`,
+		`:post_install => 'echo a' `:                      `echo a`,
+		`:ref => v0.1.0`:                                  `v0.1.0`,
+	} {
+		m := parseOpts(s)
+		for _, v := range m {
+			if v != e {
+				t.Errorf("'%v' should be '%v' for %v", v, e, s)
+			}
+		}
+	}
+	if m := parseOpts(`:tags => ['a', "b"]`); m["tags"] != "a,b" {
+		t.Errorf("'%v' should be 'a,b'", m["tags"])
+	}
+}
+
+func TestIsModuledir(t *testing.T) {
+	if m := isModuledir(`moduledir 'thirdparty'`); m != "thirdparty" {
+		t.Errorf("'%v' should be 'thirdparty'", m)
+	}
+	if m := isModuledir(`mod 'moduledir', :git => 'a'`); m != "" {
+		t.Errorf("'%v' should be empty", m)
+	}
+}
+
 func TestIsInclude(t *testing.T) {
 	m := isInclude(`include "hello"  `)
 	if !(m == "hello") {
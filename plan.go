@@ -0,0 +1,251 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Actions in PlanEntry.
+const (
+	planClone     = "clone"
+	planFetch     = "fetch"    // fetch and check out
+	planCheckout  = "checkout" // check out without fetch
+	planUnchanged = "unchanged"
+	planPurge     = "purge"
+	planUnknown   = "unknown" // the target cannot be resolved
+)
+
+// PlanEntry is what install would do for a mod or a directory to be purged.
+type PlanEntry struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	InstallPath string `json:"install_path,omitempty"` // :install_path of the mod
+	Moduledir   string `json:"moduledir,omitempty"`    // moduledir of the puppetfile
+	URL         string `json:"url,omitempty"`
+	Ref         string `json:"ref,omitempty"`
+	Action      string `json:"action"`
+	From        string `json:"from,omitempty"` // HEAD of the installed module
+	To          string `json:"to,omitempty"`   // sha1 to be checked out
+	Dirty       bool   `json:"dirty,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Plan tells what Install would do for mods without any change.
+// Target refs are resolved by ls-remote, or locally for OnlyCheckout.
+func (c Installer) Plan(ctx context.Context, mods []Mod) ([]PlanEntry, error) {
+	c, err := c.setup()
+	if err != nil {
+		return nil, err
+	}
+	n := c.Concurrency
+	if n < 1 || len(mods) < n {
+		n = len(mods)
+	}
+	plan := make([]PlanEntry, len(mods))
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i, m := range mods {
+		m.opts = m.Opts()
+		m.moddir = c.ModulePath
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, m Mod) {
+			defer func() { <-sem; wg.Done() }()
+			plan[i] = c.planMod(ctx, &m)
+		}(i, m)
+	}
+	wg.Wait()
+	return plan, ctx.Err()
+}
+
+func (c Installer) planMod(ctx context.Context, m *Mod) PlanEntry {
+	e := PlanEntry{Name: m.name, Path: m.Dest(), InstallPath: m.installPath, Moduledir: m.moduledir, Ref: m.Ref()}
+	if err := c.resolveURL(m); err != nil {
+		e.Action, e.Error = planUnknown, err.Error()
+		return e
+	}
+	e.URL = m.opts["git"]
+	ref := e.Ref
+	if ref == "" {
+		ref = "master"
+	}
+
+	dest := m.Dest()
+	if !exists(dest) {
+		e.Action = planClone
+		if c.OnlyCheckout {
+			e.Action, e.Error = planUnknown, "not installed"
+			return e
+		}
+		to, err := c.remoteSha1(ctx, e.URL, ref)
+		switch {
+		case err != nil:
+			e.Action, e.Error = planUnknown, err.Error()
+		case to == "":
+			e.Action, e.Error = planUnknown, fmt.Sprintf("%v is not found", ref)
+		}
+		e.To = to
+		return e
+	}
+
+	e.From = gitHead(dest)
+	st, err := localChanges(dest)
+	e.Dirty = err != nil || strings.TrimSpace(st) != ""
+	if c.OnlyCheckout {
+		e.To = gitSha1(dest, ref)
+	} else {
+		e.To, err = c.remoteSha1(ctx, e.URL, ref)
+		if err != nil {
+			e.Action, e.Error = planUnknown, err.Error()
+			return e
+		}
+	}
+	sha1, err := isSha1(dest, e.To)
+	if err != nil {
+		e.Action, e.Error = planUnknown, err.Error()
+		return e
+	}
+	if sha1 {
+		e.To = gitSha1(dest, e.To) // expand an abbreviated one
+	}
+	has, err := hasCommit(dest, e.To)
+	if err != nil {
+		e.Action, e.Error = planUnknown, err.Error()
+		return e
+	}
+
+	switch {
+	case e.To == "":
+		e.Action, e.Error = planUnknown, fmt.Sprintf("%v is not found", ref)
+	case e.To == e.From && gitConfig(dest, "remote.origin.url") == e.URL:
+		e.Action = planUnchanged
+	case c.OnlyCheckout || (has && gitConfig(dest, "remote.origin.url") == e.URL):
+		e.Action = planCheckout
+	default:
+		e.Action = planFetch
+	}
+	return e
+}
+
+// remoteSha1 resolves ref by ls-remote. A tag is preferred to a branch,
+// and a sha1 which no ref has is returned as it is.
+func (c Installer) remoteSha1(ctx context.Context, url, ref string) (string, error) {
+	var refs map[string]string
+	err := c.limiter.do(ctx, url, func() (err error) {
+		refs, err = c.gitLsRemoteURL(ctx, url, "refs/tags/"+ref, "refs/tags/"+ref+"^{}", "refs/heads/"+ref)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, k := range []string{"refs/tags/" + ref + "^{}", "refs/tags/" + ref, "refs/heads/" + ref} {
+		if s, ok := refs[k]; ok {
+			return s, nil
+		}
+	}
+	if sha1Pattern.MatchString(ref) {
+		return ref, nil
+	}
+	return "", nil
+}
+
+// purgePlan returns entries for directories which would be purged.
+func purgePlan(dir string, mods []Mod, keep []string) ([]PlanEntry, error) {
+	ds, err := unmanaged(dir, mods, keep)
+	if err != nil {
+		return nil, err
+	}
+	plan := make([]PlanEntry, len(ds))
+	for i, d := range ds {
+		plan[i] = PlanEntry{Name: filepath.Base(d), Path: d, Action: planPurge}
+	}
+	return plan, nil
+}
+
+// printPlan prints a plan in a line for each entry.
+func printPlan(w io.Writer, plan []PlanEntry) {
+	for _, e := range plan {
+		s := fmt.Sprintf("%-10v %v", e.Action, e.Name)
+		switch e.Action {
+		case planClone:
+			s += fmt.Sprintf("\t-> %v (%v)", short(e.To), e.Ref)
+		case planFetch, planCheckout:
+			s += fmt.Sprintf("\t%v -> %v (%v)", short(e.From), short(e.To), e.Ref)
+		case planUnchanged:
+			s += fmt.Sprintf("\t%v (%v)", short(e.From), e.Ref)
+		case planPurge:
+			s += "\t" + e.Path
+		}
+		if e.Dirty {
+			s += "\tlocal changes"
+		}
+		if e.Error != "" {
+			s += "\t" + e.Error
+		}
+		fmt.Fprintln(w, s)
+	}
+}
+
+func short(sha1 string) string {
+	if len(sha1) > 7 {
+		return sha1[:7]
+	}
+	return sha1
+}
+
+func writePlanFile(path string, plan []PlanEntry) error {
+	b, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// readPlanFile reads a plan and returns mods pinned at the planned sha1
+// and directories to be purged. It fails if the plan has unknown entries,
+// or paths which are not in modpath nor directories declared by the puppetfile.
+func readPlanFile(path, modpath string) ([]Mod, []string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var plan []PlanEntry
+	if err := json.Unmarshal(b, &plan); err != nil {
+		return nil, nil, err
+	}
+	mods := make([]Mod, 0)
+	purged := make([]string, 0)
+	purgeable := map[string]bool{filepath.Clean(modpath): true}
+	for _, e := range plan {
+		switch e.Action {
+		case planPurge:
+			purged = append(purged, e.Path)
+		case planUnknown:
+			return nil, nil, fmt.Errorf("%v cannot be applied: %v", e.Name, e.Error)
+		default:
+			if e.To == "" {
+				return nil, nil, fmt.Errorf("%v has no sha1 to check out", e.Name)
+			}
+			m := Mod{name: e.Name, opts: ModOpts{"git": e.URL, "ref": e.To}, installPath: e.InstallPath, moduledir: e.Moduledir, moddir: modpath}
+			if filepath.Clean(m.Dest()) != filepath.Clean(e.Path) {
+				return nil, nil, fmt.Errorf("%v in the plan is not in %v", e.Path, m.dir())
+			}
+			if m.installPath == "" {
+				purgeable[filepath.Clean(m.dir())] = true
+			}
+			mods = append(mods, m)
+		}
+	}
+	for _, p := range purged {
+		if !purgeable[filepath.Dir(filepath.Clean(p))] {
+			return nil, nil, fmt.Errorf("%v in the plan is not in %v", p, modpath)
+		}
+	}
+	return mods, purged, nil
+}
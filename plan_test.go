@@ -0,0 +1,121 @@
+package librarianpuppetgo
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlan(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	up := filepath.Join(dir, "upstream")
+	mdir := filepath.Join(dir, "modules")
+	mods := []Mod{
+		{name: "foo", opts: ModOpts{"git": up, "ref": "v0.1.0"}},
+		{name: "bar", opts: ModOpts{"git": up, "ref": "develop"}},
+	}
+	c := Installer{ModulePath: mdir, BranchStrategy: branchReset}
+
+	plan, err := c.Plan(ctx, mods)
+	assert.Nil(t, err)
+	assert.Equal(t, planClone, plan[0].Action)
+	assert.Equal(t, gitSha1(up, "v0.1.0"), plan[0].To)
+	assert.Equal(t, planClone, plan[1].Action)
+	assert.Equal(t, gitSha1(up, "develop"), plan[1].To)
+	assert.False(t, exists(mdir))
+
+	_, err = c.Install(ctx, mods)
+	assert.Nil(t, err)
+	plan, _ = c.Plan(ctx, mods)
+	assert.Equal(t, planUnchanged, plan[0].Action)
+	assert.Equal(t, planUnchanged, plan[1].Action)
+
+	cmd := exec.Command("sh", "-c", "git checkout -q develop && echo d >> README && git commit -qam d && git checkout -q master")
+	cmd.Dir = up
+	assert.Nil(t, cmd.Run())
+	ioutil.WriteFile(filepath.Join(mdir, "bar", "README"), []byte("changed"), 0644)
+	plan, _ = c.Plan(ctx, []Mod{
+		{name: "foo", opts: ModOpts{"git": up, "ref": "master"}},
+		{name: "bar", opts: ModOpts{"git": up, "ref": "develop"}},
+		{name: "baz", opts: ModOpts{"git": up, "ref": "no-such-ref"}},
+	})
+	assert.Equal(t, planCheckout, plan[0].Action)
+	assert.Equal(t, gitSha1(up, "v0.1.0"), plan[0].From)
+	assert.Equal(t, gitSha1(up, "master"), plan[0].To)
+	assert.Equal(t, planFetch, plan[1].Action)
+	assert.Equal(t, gitSha1(up, "develop"), plan[1].To)
+	assert.True(t, plan[1].Dirty)
+	assert.Equal(t, planUnknown, plan[2].Action)
+
+	os.Mkdir(filepath.Join(mdir, "old"), 0755)
+	ps, err := purgePlan(mdir, mods, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []PlanEntry{{Name: "old", Path: filepath.Join(mdir, "old"), Action: planPurge}}, ps)
+
+	buf := bytes.NewBuffer([]byte{})
+	printPlan(buf, plan[:1])
+	assert.Equal(t, "checkout   foo\t"+short(plan[0].From)+" -> "+short(plan[0].To)+" (master)\n", buf.String())
+}
+
+func TestReadPlanFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lpg-test")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "plan.json")
+
+	assert.Nil(t, writePlanFile(path, []PlanEntry{
+		{Name: "foo", Path: "modules/foo", URL: "a@b.com", Ref: "master", Action: planFetch, To: "abc"},
+		{Name: "old", Path: "modules/old", Action: planPurge},
+	}))
+	mods, purged, err := readPlanFile(path, "modules")
+	assert.Nil(t, err)
+	assert.Equal(t, []Mod{{name: "foo", opts: ModOpts{"git": "a@b.com", "ref": "abc"}, moddir: "modules"}}, mods)
+	assert.Equal(t, []string{"modules/old"}, purged)
+
+	_, _, err = readPlanFile(path, "other")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, writePlanFile(path, []PlanEntry{{Name: "foo", Action: planUnknown, Error: "not found"}}))
+	_, _, err = readPlanFile(path, "modules")
+	assert.NotNil(t, err)
+}
+
+func TestReadPlanFileInstallPath(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lpg-test")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "plan.json")
+
+	assert.Nil(t, writePlanFile(path, []PlanEntry{
+		{Name: "foo", Path: "site/foo", InstallPath: "site", URL: "a@b.com", Action: planFetch, To: "abc"},
+		{Name: "bar", Path: "vendor/bar", Moduledir: "vendor", URL: "a@b.com", Action: planClone, To: "def"},
+		{Name: "old", Path: "vendor/old", Action: planPurge},
+	}))
+	mods, purged, err := readPlanFile(path, "modules")
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(mods)) {
+		assert.Equal(t, "site/foo", mods[0].Dest())
+		assert.Equal(t, "vendor/bar", mods[1].Dest())
+	}
+	assert.Equal(t, []string{"vendor/old"}, purged)
+
+	// install_path is never purged
+	assert.Nil(t, writePlanFile(path, []PlanEntry{
+		{Name: "foo", Path: "site/foo", InstallPath: "site", URL: "a@b.com", Action: planFetch, To: "abc"},
+		{Name: "old", Path: "site/old", Action: planPurge},
+	}))
+	_, _, err = readPlanFile(path, "modules")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, writePlanFile(path, []PlanEntry{
+		{Name: "foo", Path: "elsewhere/foo", InstallPath: "site", URL: "a@b.com", Action: planFetch, To: "abc"},
+	}))
+	_, _, err = readPlanFile(path, "modules")
+	assert.NotNil(t, err)
+}
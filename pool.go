@@ -0,0 +1,82 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Actions in Result.
+const (
+	ActionCloned    = "cloned"
+	ActionUpdated   = "updated"
+	ActionUnchanged = "unchanged"
+	ActionFailed    = "failed"
+	ActionCanceled  = "canceled"
+)
+
+// Result is what install did for a mod.
+type Result struct {
+	Mod      Mod
+	Action   string // cloned, updated, unchanged, failed or canceled
+	Op       string // the last git operation, e.g. clone, fetch, checkout
+	OldSha1  string // HEAD before install, empty if it's not installed
+	NewSha1  string // HEAD after install
+	Start    time.Time
+	Duration time.Duration
+	Retries  int
+	Dirty    string // what was done for local changes
+	Err      error
+}
+
+// pool calls f for each mod with n workers at most and returns results
+// in the order of mods. Mods which are not started before ctx is done
+// are returned as canceled.
+func pool(ctx context.Context, n int, mods []Mod, f func(context.Context, Mod) Result) []Result {
+	if n < 1 || len(mods) < n {
+		n = len(mods)
+	}
+	results := make([]Result, len(mods))
+	tasks := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tasks {
+				if ctx.Err() != nil {
+					results[i] = Result{Mod: mods[i], Action: ActionCanceled, Err: ctx.Err()}
+					continue
+				}
+				results[i] = f(ctx, mods[i])
+			}
+		}()
+	}
+
+dispatch:
+	for i := range mods {
+		select {
+		case tasks <- i:
+		case <-ctx.Done():
+			for j := i; j < len(mods); j++ {
+				results[j] = Result{Mod: mods[j], Action: ActionCanceled, Err: ctx.Err()}
+			}
+			break dispatch
+		}
+	}
+	close(tasks)
+	wg.Wait()
+	return results
+}
+
+// failedResults returns results which are failed or canceled.
+func failedResults(results []Result) []Result {
+	failed := make([]Result, 0)
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,63 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool(t *testing.T) {
+	mods := make([]Mod, 10)
+	for i := range mods {
+		mods[i] = Mod{name: fmt.Sprintf("m%d", i)}
+	}
+
+	var mu sync.Mutex
+	running, max := 0, 0
+	results := pool(context.Background(), 3, mods, func(ctx context.Context, m Mod) Result {
+		mu.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if m.name == "m4" {
+			return Result{Mod: m, Action: ActionFailed, Err: errors.New("x")}
+		}
+		return Result{Mod: m, Action: ActionUnchanged}
+	})
+	assert.Equal(t, 3, max)
+	assert.Equal(t, 10, len(results))
+	for i, r := range results {
+		assert.Equal(t, mods[i].name, r.Mod.name)
+	}
+	failed := failedResults(results)
+	assert.Equal(t, 1, len(failed))
+	assert.Equal(t, "m4", failed[0].Mod.name)
+
+	assert.Equal(t, 0, len(pool(context.Background(), 0, []Mod{}, nil)))
+}
+
+func TestPoolCancel(t *testing.T) {
+	mods := []Mod{{name: "a"}, {name: "b"}, {name: "c"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	results := pool(ctx, 1, mods, func(ctx context.Context, m Mod) Result {
+		cancel()
+		return Result{Mod: m, Action: ActionUnchanged}
+	})
+	assert.Equal(t, ActionUnchanged, results[0].Action)
+	for _, r := range results[1:] {
+		assert.Equal(t, ActionCanceled, r.Action)
+		assert.Equal(t, context.Canceled, r.Err)
+	}
+	assert.Equal(t, 2, len(failedResults(results)))
+}
@@ -0,0 +1,124 @@
+package librarianpuppetgo
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const progressInterval = 200 * time.Millisecond
+
+// progress shows what each worker is doing. On a terminal it redraws
+// a view of running mods, otherwise it prints a line for each event.
+type progress struct {
+	mu      sync.Mutex
+	w       io.Writer
+	tty     bool
+	total   int
+	done    int
+	start   time.Time
+	running map[string]*modProgress // by mod name
+	lines   int                     // lines drawn last time
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+type modProgress struct {
+	name  string
+	phase string
+	start time.Time
+}
+
+func newProgress(w io.Writer, tty bool, total int) *progress {
+	return &progress{
+		w:       w,
+		tty:     tty,
+		total:   total,
+		start:   time.Now(),
+		running: map[string]*modProgress{},
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Start redraws the view periodically on a terminal until Stop is called.
+func (p *progress) Start() {
+	go func() {
+		defer close(p.stopped)
+		if !p.tty {
+			<-p.stop
+			return
+		}
+		t := time.NewTicker(progressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				p.mu.Lock()
+				p.draw(time.Now())
+				p.mu.Unlock()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop draws the view for the last time.
+func (p *progress) Stop() {
+	close(p.stop)
+	<-p.stopped
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tty {
+		p.draw(time.Now())
+	}
+}
+
+// Event is given to Installer.OnEvent.
+func (p *progress) Event(e Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := e.Mod.name
+	switch e.Phase {
+	case phaseStart:
+		p.running[n] = &modProgress{name: n, phase: e.Phase, start: e.Time}
+	case phaseDone:
+		delete(p.running, n)
+		p.done++
+	default:
+		if s, ok := p.running[n]; ok {
+			s.phase = e.Phase
+		}
+	}
+	if p.tty {
+		return
+	}
+	switch e.Phase {
+	case phaseStart:
+	case phaseDone:
+		fmt.Fprintf(p.w, "[%v/%v] %v\t%v\t%v\n", p.done, p.total, n, e.Result.Action, e.Result.Duration.Round(time.Millisecond))
+	default:
+		fmt.Fprintf(p.w, "[%v/%v] %v\t%v\n", p.done, p.total, n, e.Phase)
+	}
+}
+
+// draw erases the last view and draws running mods in the order of start.
+func (p *progress) draw(now time.Time) {
+	if p.lines > 0 {
+		fmt.Fprintf(p.w, "\x1b[%dA\x1b[J", p.lines)
+	}
+	ss := make([]*modProgress, 0, len(p.running))
+	for _, s := range p.running {
+		ss = append(ss, s)
+	}
+	sort.Slice(ss, func(i, j int) bool { return ss[i].start.Before(ss[j].start) })
+
+	fmt.Fprintf(p.w, "[%v/%v] active: %v\telapsed: %v\n", p.done, p.total, len(ss), now.Sub(p.start).Round(time.Second))
+	for _, s := range ss {
+		fmt.Fprintf(p.w, "  %-30v %-10v %v\n", s.name, s.phase, now.Sub(s.start).Round(100*time.Millisecond))
+	}
+	p.lines = len(ss) + 1
+}
@@ -0,0 +1,46 @@
+package librarianpuppetgo
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressLines(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	p := newProgress(buf, false, 2)
+	p.Start()
+	m := Mod{name: "foo"}
+	p.Event(Event{Mod: m, Phase: phaseStart, Time: time.Now()})
+	p.Event(Event{Mod: m, Phase: "clone", Time: time.Now()})
+	p.Event(Event{Mod: m, Phase: phaseDone, Time: time.Now(), Result: &Result{Action: ActionCloned, Duration: time.Second}})
+	p.Stop()
+	assert.Equal(t, "[0/2] foo\tclone\n[1/2] foo\tcloned\t1s\n", buf.String())
+}
+
+func TestProgressDraw(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	p := newProgress(buf, true, 3)
+	now := p.start
+	p.Event(Event{Mod: Mod{name: "foo"}, Phase: phaseStart, Time: now})
+	p.Event(Event{Mod: Mod{name: "bar"}, Phase: phaseStart, Time: now.Add(time.Second)})
+	p.Event(Event{Mod: Mod{name: "foo"}, Phase: "fetch", Time: now.Add(time.Second)})
+	assert.Equal(t, "", buf.String())
+
+	p.draw(now.Add(2 * time.Second))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, "[0/3] active: 2\telapsed: 2s", lines[0])
+	assert.Contains(t, lines[1], "foo")
+	assert.Contains(t, lines[1], "fetch")
+	assert.Contains(t, lines[1], "2s")
+	assert.Contains(t, lines[2], "bar")
+	assert.Contains(t, lines[2], "start")
+
+	buf.Reset()
+	p.Event(Event{Mod: Mod{name: "foo"}, Phase: phaseDone, Time: now, Result: &Result{Action: ActionUpdated}})
+	p.draw(now.Add(3 * time.Second))
+	assert.True(t, strings.HasPrefix(buf.String(), "\x1b[3A\x1b[J[1/3] active: 1"), buf.String())
+}
@@ -0,0 +1,127 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type purgeOpts struct {
+	dryRun bool
+	trash  string   // move directories into it instead of removing
+	keep   []string // globs of directory names never purged
+}
+
+// purgeFile removes directories in dir which no mod in a puppetfile declares
+// holding locks of directories which mods are installed into. It's for the purge command.
+func purgeFile(path, dir string, opts purgeOpts, wait time.Duration) error {
+	mods := parse(path)
+	if !opts.dryRun {
+		unlock, err := lockDirs(context.Background(), modDirs(dir, mods, nil), wait)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	return purge(dir, mods, opts)
+}
+
+// purge removes directories in dir which no mod declares.
+func purge(dir string, mods []Mod, opts purgeOpts) error {
+	ds, err := unmanaged(dir, mods, opts.keep)
+	if err != nil {
+		return err
+	}
+	return purgeDirs(ds, opts)
+}
+
+// purgeDirs removes ds or moves them into the trash.
+func purgeDirs(ds []string, opts purgeOpts) error {
+	for _, d := range ds {
+		if opts.dryRun {
+			fmt.Println(d)
+			continue
+		}
+		if opts.trash == "" {
+			log.Printf("[purge] %v", d)
+			if err := os.RemoveAll(d); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(opts.trash, 0755); err != nil {
+			return err
+		}
+		t := filepath.Join(opts.trash, filepath.Base(d)+"."+time.Now().Format("20060102150405"))
+		log.Printf("[purge] %v to %v", d, t)
+		if err := os.Rename(d, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmanaged returns directories in the module path dir, or moduledir given
+// in a puppetfile, which are not where a mod is installed and match no glob
+// in keep. Hidden ones such as staging and backup directories are ignored,
+// and directories given by :install_path are never purged.
+func unmanaged(dir string, mods []Mod, keep []string) ([]string, error) {
+	dests, seen := map[string]bool{}, map[string]bool{}
+	dirs := make([]string, 0)
+	for _, m := range mods {
+		m.moddir = dir
+		dests[filepath.Clean(m.Dest())] = true
+		if d := filepath.Clean(m.dir()); m.installPath == "" && !seen[d] {
+			seen[d] = true
+			dirs = append(dirs, d)
+		}
+	}
+	if len(dirs) == 0 {
+		dirs = append(dirs, dir)
+	}
+
+	ds := make([]string, 0)
+	for _, d := range dirs {
+		u, err := unmanagedIn(d, dests, keep)
+		if err != nil {
+			return nil, err
+		}
+		ds = append(ds, u...)
+	}
+	return ds, nil
+}
+
+// unmanagedIn returns directories in dir which are not in dests.
+func unmanagedIn(dir string, dests map[string]bool, keep []string) ([]string, error) {
+	fs, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ds := make([]string, 0)
+	for _, f := range fs {
+		n := f.Name()
+		if !f.IsDir() || strings.HasPrefix(n, ".") || dests[filepath.Join(dir, n)] {
+			continue
+		}
+		kept := false
+		for _, k := range keep {
+			if ok, _ := filepath.Match(k, n); ok {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			ds = append(ds, filepath.Join(dir, n))
+		}
+	}
+	return ds, nil
+}
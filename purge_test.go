@@ -0,0 +1,64 @@
+package librarianpuppetgo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurge(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lpg-test")
+	defer os.RemoveAll(dir)
+	mdir := filepath.Join(dir, "modules")
+	for _, d := range []string{"foo", "bar", "old", "local_site", ".foo.staging"} {
+		os.MkdirAll(filepath.Join(mdir, d), 0755)
+	}
+	ioutil.WriteFile(filepath.Join(mdir, "README"), []byte("a"), 0644)
+
+	mods, _ := parsePuppetfile(r(`
+mod 'foo', :git => 'a'
+mod 'puppetlabs/bar', '1.0.0'
+`))
+	ds, err := unmanaged(mdir, mods, []string{"local_*"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(mdir, "old")}, ds)
+
+	ds, err = unmanaged(filepath.Join(dir, "missing"), mods, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(ds))
+
+	assert.Nil(t, purge(mdir, mods, purgeOpts{dryRun: true}))
+	assert.True(t, exists(filepath.Join(mdir, "old")))
+
+	trash := filepath.Join(dir, "trash")
+	assert.Nil(t, purge(mdir, mods, purgeOpts{trash: trash, keep: []string{"local_*"}}))
+	assert.False(t, exists(filepath.Join(mdir, "old")))
+	assert.True(t, exists(filepath.Join(mdir, "local_site")))
+	fs, _ := filepath.Glob(filepath.Join(trash, "old.*"))
+	assert.Equal(t, 1, len(fs))
+
+	assert.Nil(t, purge(mdir, mods, purgeOpts{}))
+	assert.False(t, exists(filepath.Join(mdir, "local_site")))
+	assert.True(t, exists(filepath.Join(mdir, "foo")))
+	assert.True(t, exists(filepath.Join(mdir, ".foo.staging")))
+}
+
+func TestPurgeInstallPath(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lpg-test")
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"modules/old", "vendor/foo", "vendor/old", "site/roles", "site/profiles"} {
+		os.MkdirAll(filepath.Join(dir, d), 0755)
+	}
+
+	pf, _ := ParsePuppetfile(r(`
+moduledir 'vendor'
+mod 'foo', :git => 'a'
+mod 'roles', :git => 'a', :install_path => 'site'
+`), ParseOptions{Dir: dir})
+	ds, err := unmanaged(filepath.Join(dir, "modules"), pf.Mods, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "vendor", "old")}, ds)
+}
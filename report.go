@@ -0,0 +1,166 @@
+package librarianpuppetgo
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Formats of a report.
+const (
+	reportJSON  = "json"
+	reportJUnit = "junit"
+)
+
+type reportEntry struct {
+	Name     string       `json:"name"`
+	URL      string       `json:"url"`
+	Ref      string       `json:"ref"`
+	Sha1     string       `json:"sha1"`
+	OldSha1  string       `json:"old_sha1"`
+	Action   string       `json:"action"`
+	Op       string       `json:"op,omitempty"`
+	Duration float64      `json:"duration"` // in seconds
+	Retries  int          `json:"retries"`
+	Dirty    string       `json:"dirty,omitempty"`
+	Error    *reportError `json:"error,omitempty"`
+}
+
+type reportError struct {
+	Class   string `json:"class"`
+	Message string `json:"message"`
+	Stderr  string `json:"stderr,omitempty"`
+}
+
+func newReportEntry(r Result) reportEntry {
+	e := reportEntry{
+		Name:     r.Mod.name,
+		URL:      r.Mod.opts["git"],
+		Ref:      r.Mod.Ref(),
+		Sha1:     r.NewSha1,
+		OldSha1:  r.OldSha1,
+		Action:   r.Action,
+		Op:       r.Op,
+		Duration: r.Duration.Seconds(),
+		Retries:  r.Retries,
+		Dirty:    r.Dirty,
+	}
+	if r.Err != nil {
+		e.Error = &reportError{Class: classify(r.Err), Message: r.Err.Error()}
+		if g, ok := r.Err.(*GitError); ok {
+			e.Error.Stderr = g.Stderr
+		}
+	}
+	return e
+}
+
+// checkReportFormat returns an error for an unknown format. Empty is valid for no report.
+func checkReportFormat(format string) error {
+	switch format {
+	case "", reportJSON, reportJUnit:
+		return nil
+	}
+	return fmt.Errorf("unknown report format: %v", format)
+}
+
+// writeReportFile writes a report of results to path, or stdout if path is empty.
+func writeReportFile(path, format string, results []Result) error {
+	if path == "" {
+		return writeReport(os.Stdout, format, results)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeReport(f, format, results); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeReport(w io.Writer, format string, results []Result) error {
+	switch format {
+	case reportJSON:
+		es := make([]reportEntry, len(results))
+		for i, r := range results {
+			es[i] = newReportEntry(r)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Modules []reportEntry `json:"modules"`
+		}{es})
+	case reportJUnit:
+		return writeJUnit(w, results)
+	}
+	return checkReportFormat(format)
+}
+
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// writeJUnit writes a test case for each mod. A failed mod is a failure
+// and a canceled one is an error.
+func writeJUnit(w io.Writer, results []Result) error {
+	s := junitSuite{Name: "librarian-puppet-go", Tests: len(results)}
+	var total time.Duration
+	for _, r := range results {
+		e := newReportEntry(r)
+		total += r.Duration
+		c := junitCase{
+			Name:      e.Name,
+			Classname: "install",
+			Time:      e.Duration,
+			SystemOut: fmt.Sprintf("action: %v\nurl: %v\nref: %v\nsha1: %v\n", e.Action, e.URL, e.Ref, e.Sha1),
+		}
+		if e.Error != nil {
+			f := &junitFailure{Message: e.Error.Message, Type: e.Error.Class, Body: e.Error.Stderr}
+			if r.Action == ActionCanceled {
+				c.Error = f
+				s.Errors++
+			} else {
+				c.Failure = f
+				s.Failures++
+			}
+		}
+		s.Cases = append(s.Cases, c)
+	}
+	s.Time = total.Seconds()
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
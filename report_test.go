@@ -0,0 +1,54 @@
+package librarianpuppetgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testResults() []Result {
+	return []Result{
+		{Mod: Mod{name: "foo", opts: ModOpts{"git": "https://a/foo.git", "ref": "v0.1.0"}}, Action: ActionCloned, NewSha1: "abc", Duration: time.Second},
+		{Mod: Mod{name: "bar", opts: ModOpts{"git": "https://a/bar.git", "ref": "master"}}, Action: ActionFailed, Op: "fetch", OldSha1: "def", NewSha1: "def",
+			Err: &GitError{Op: "fetch", Err: errors.New("exit status 128"), Stderr: "fatal: Authentication failed"}},
+		{Mod: Mod{name: "baz", opts: ModOpts{}}, Action: ActionCanceled, Err: context.Canceled},
+	}
+}
+
+func TestWriteReportJSON(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	assert.Nil(t, writeReport(buf, reportJSON, testResults()))
+
+	var v struct {
+		Modules []reportEntry `json:"modules"`
+	}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &v))
+	assert.Equal(t, 3, len(v.Modules))
+	assert.Equal(t, reportEntry{Name: "foo", URL: "https://a/foo.git", Ref: "v0.1.0", Sha1: "abc", Action: ActionCloned, Duration: 1}, v.Modules[0])
+	assert.Equal(t, errAuth, v.Modules[1].Error.Class)
+	assert.Equal(t, "fatal: Authentication failed", v.Modules[1].Error.Stderr)
+	assert.Equal(t, "def", v.Modules[1].OldSha1)
+}
+
+func TestWriteReportJUnit(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	assert.Nil(t, writeReport(buf, reportJUnit, testResults()))
+	s := buf.String()
+	assert.True(t, strings.HasPrefix(s, "<?xml"))
+	assert.Contains(t, s, `<testsuite name="librarian-puppet-go" tests="3" failures="1" errors="1"`)
+	assert.Contains(t, s, `<testcase name="foo" classname="install" time="1">`)
+	assert.Contains(t, s, `<failure message="fetch: exit status 128: fatal: Authentication failed" type="`+errAuth+`">`)
+	assert.Contains(t, s, `<error message="context canceled" type="`+errCanceled+`">`)
+}
+
+func TestCheckReportFormat(t *testing.T) {
+	assert.Nil(t, checkReportFormat(""))
+	assert.Nil(t, checkReportFormat(reportJUnit))
+	assert.NotNil(t, checkReportFormat("xml"))
+}
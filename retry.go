@@ -0,0 +1,55 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+)
+
+const maxRetryWait = 60 * time.Second
+
+// Classes of errors which never succeed by retrying.
+var permanentErrors = map[string]bool{
+	errAuth:          true,
+	errRepoNotFound:  true,
+	errRefNotFound:   true,
+	errLocalChanges:  true,
+	errMergeConflict: true,
+}
+
+// retry calls f until it succeeds, fails permanently, ctx is done
+// or it is called n+1 times. It returns the number of retries.
+func retry(ctx context.Context, l *log.Logger, n int, wait time.Duration, f func() error) (int, error) {
+	var err error
+	for i := 0; ; i++ {
+		err = f()
+		if err == nil || i >= n || isPermanent(err) || ctx.Err() != nil {
+			return i, err
+		}
+		d := backoff(wait, i)
+		l.Printf("retry %v/%v in %v: %v", i+1, n, d, err)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return i, err
+		}
+	}
+}
+
+// backoff returns wait * 2^i with jitter, which is between half and full of it.
+func backoff(wait time.Duration, i int) time.Duration {
+	d := wait << uint(i)
+	if d > maxRetryWait || d <= 0 {
+		d = maxRetryWait
+	}
+	half := int64(d / 2)
+	if half == 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+func isPermanent(err error) bool {
+	return permanentErrors[classify(err)]
+}
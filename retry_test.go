@@ -0,0 +1,64 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	n, err := retry(context.Background(), logger, 3, time.Millisecond, func() error {
+		calls++
+		if calls < 3 {
+			return context.DeadlineExceeded
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	n, err = retry(context.Background(), logger, 2, time.Millisecond, func() error {
+		calls++
+		return errors.New("exit status 128")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	n, err = retry(context.Background(), logger, 5, time.Millisecond, func() error {
+		calls++
+		return &GitError{Err: errors.New("exit status 128"), Stderr: "ERROR: Repository not found."}
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 1, calls)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	n, err = retry(ctx, logger, 5, time.Hour, func() error {
+		calls++
+		cancel()
+		return context.DeadlineExceeded
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 1, calls)
+}
+
+func TestBackoff(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		d := backoff(time.Second, i)
+		max := time.Second << uint(i)
+		if max > maxRetryWait {
+			max = maxRetryWait
+		}
+		assert.True(t, max/2 <= d && d <= max, "%v: %v", i, d)
+	}
+}
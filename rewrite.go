@@ -0,0 +1,76 @@
+package librarianpuppetgo
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rewrite replaces a URL of git repository which starts with Prefix
+// or matches Regexp. $1 in Replace is expanded with a submatch of Regexp.
+type Rewrite struct {
+	Prefix  string `json:"prefix,omitempty"`
+	Regexp  string `json:"regexp,omitempty"`
+	Replace string `json:"replace"`
+}
+
+type rewriteRule struct {
+	Rewrite
+	re *regexp.Regexp
+}
+
+type rewriter []rewriteRule
+
+func newRewriter(rs []Rewrite) (rewriter, error) {
+	w := make(rewriter, 0)
+	for _, r := range rs {
+		if (r.Prefix == "") == (r.Regexp == "") {
+			return nil, fmt.Errorf("rewrite needs either prefix or regexp: %+v", r)
+		}
+		rule := rewriteRule{Rewrite: r}
+		if r.Regexp != "" {
+			re, err := regexp.Compile(r.Regexp)
+			if err != nil {
+				return nil, err
+			}
+			rule.re = re
+		}
+		w = append(w, rule)
+	}
+	return w, nil
+}
+
+// parseRewrites parses FROM=TO given in command line.
+func parseRewrites(args []string, isRegexp bool) ([]Rewrite, error) {
+	rs := make([]Rewrite, 0)
+	for _, a := range args {
+		i := strings.Index(a, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("rewrite must be FROM=TO: %v", a)
+		}
+		r := Rewrite{Replace: a[i+1:]}
+		if isRegexp {
+			r.Regexp = a[:i]
+		} else {
+			r.Prefix = a[:i]
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+// rewrite returns url replaced by the first rule matched.
+func (w rewriter) rewrite(url string) string {
+	for _, r := range w {
+		if r.re != nil {
+			if r.re.MatchString(url) {
+				return r.re.ReplaceAllString(url, r.Replace)
+			}
+			continue
+		}
+		if strings.HasPrefix(url, r.Prefix) {
+			return r.Replace + url[len(r.Prefix):]
+		}
+	}
+	return url
+}
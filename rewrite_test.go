@@ -0,0 +1,45 @@
+package librarianpuppetgo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewrite(t *testing.T) {
+	w, err := newRewriter([]Rewrite{
+		{Prefix: "https://github.com/", Replace: "https://git.example.com/github/"},
+		{Regexp: `^git@github\.com:(.*)$`, Replace: "ssh://git@git.example.com/github/$1"},
+		{Prefix: "https://github.com/foo/", Replace: "never used"},
+	})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		url, exp string
+	}{
+		{"https://github.com/foo/bar.git", "https://git.example.com/github/foo/bar.git"},
+		{"git@github.com:foo/bar.git", "ssh://git@git.example.com/github/foo/bar.git"},
+		{"git@bitbucket.org:foo/bar.git", "git@bitbucket.org:foo/bar.git"},
+	}
+	for _, e := range tests {
+		assert.Equal(t, e.exp, w.rewrite(e.url))
+	}
+
+	_, err = newRewriter([]Rewrite{{Replace: "a"}})
+	assert.NotNil(t, err)
+	_, err = newRewriter([]Rewrite{{Regexp: "(", Replace: "a"}})
+	assert.NotNil(t, err)
+}
+
+func TestParseRewrites(t *testing.T) {
+	rs, err := parseRewrites([]string{"https://github.com/=https://mirror/"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []Rewrite{{Prefix: "https://github.com/", Replace: "https://mirror/"}}, rs)
+
+	rs, err = parseRewrites([]string{"^git@(.*)$=ssh://$1"}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []Rewrite{{Regexp: "^git@(.*)$", Replace: "ssh://$1"}}, rs)
+
+	_, err = parseRewrites([]string{"no-separator"}, false)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,68 @@
+package librarianpuppetgo
+
+import (
+	"path"
+)
+
+// Selector selects mods by globs of names, tags and exclusion.
+// A name glob matches either the name or user/name. All mods are
+// selected if neither Names nor Tags is given.
+type Selector struct {
+	Names   []string // globs of names, e.g. stdlib, apache_*, puppetlabs/*
+	Tags    []string // names given by :tags => [...]
+	Exclude []string // globs of names never selected
+}
+
+// Check returns an error if a glob is malformed.
+func (s Selector) Check() error {
+	for _, g := range append(append([]string{}, s.Names...), s.Exclude...) {
+		if _, err := path.Match(g, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match reports whether m is selected.
+func (s Selector) Match(m Mod) bool {
+	if matchName(s.Exclude, m) {
+		return false
+	}
+	if len(s.Names) == 0 && len(s.Tags) == 0 {
+		return true
+	}
+	if matchName(s.Names, m) {
+		return true
+	}
+	for _, t := range m.Tags() {
+		for _, u := range s.Tags {
+			if t == u {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Select returns mods which are selected in the order of mods.
+func (s Selector) Select(mods []Mod) []Mod {
+	ms := make([]Mod, 0)
+	for _, m := range mods {
+		if s.Match(m) {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+func matchName(globs []string, m Mod) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, m.name); ok {
+			return true
+		}
+		if ok, _ := path.Match(g, m.Fullname()); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package librarianpuppetgo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelector(t *testing.T) {
+	mods, _ := parsePuppetfile(r(`
+mod 'puppetlabs/stdlib', '4.1.0'
+mod 'apache', :git => 'a@b.com', :tags => ['web']
+mod 'apache_vhost', :git => 'a@b.com'
+mod 'mysql', :git => 'a@b.com', :tags => ['db', 'web']
+`))
+	names := func(ms []Mod) []string {
+		ns := make([]string, 0)
+		for _, m := range ms {
+			ns = append(ns, m.name)
+		}
+		return ns
+	}
+
+	assert.Equal(t, 4, len(Selector{}.Select(mods)))
+	assert.Equal(t, []string{"stdlib", "apache_vhost"}, names(Selector{Names: []string{"stdlib", "*_vhost"}}.Select(mods)))
+	assert.Equal(t, []string{"stdlib"}, names(Selector{Names: []string{"puppetlabs/*"}}.Select(mods)))
+	assert.Equal(t, []string{"apache", "mysql"}, names(Selector{Tags: []string{"web"}}.Select(mods)))
+	assert.Equal(t, []string{"stdlib", "mysql"}, names(Selector{Names: []string{"stdlib"}, Tags: []string{"db"}}.Select(mods)))
+	assert.Equal(t, []string{"stdlib", "mysql"}, names(Selector{Exclude: []string{"apache*"}}.Select(mods)))
+	assert.Equal(t, []string{"apache"}, names(Selector{Tags: []string{"web"}, Exclude: []string{"mysql"}}.Select(mods)))
+
+	assert.Nil(t, Selector{Names: []string{"a*"}}.Check())
+	assert.NotNil(t, Selector{Exclude: []string{"[a"}}.Check())
+}
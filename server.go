@@ -0,0 +1,305 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ServerRoute maps pushes to a repository and branch to an install.
+type ServerRoute struct {
+	Repository string `json:"repository"`  // glob of full names such as tmtk75/control
+	Branch     string `json:"branch"`      // glob of branches, all if empty
+	Puppetfile string `json:"puppetfile"`  // includes are resolved from its directory
+	ModulePath string `json:"module_path"` // "modules" if empty
+	Purge      bool   `json:"purge"`       // purge directories which no mod declares
+}
+
+// ServerConfig is "server" in the config.
+type ServerConfig struct {
+	Secret string        `json:"secret"`
+	Routes []ServerRoute `json:"routes"`
+}
+
+// States of a deploy.
+const (
+	deployQueued    = "queued"
+	deployRunning   = "running"
+	deploySucceeded = "succeeded"
+	deployFailed    = "failed"
+)
+
+// deploysKept is the number of deploys shown by the status endpoint.
+const deploysKept = 100
+
+// maxWebhookBody limits a payload.
+const maxWebhookBody = 10 << 20
+
+type deployStatus struct {
+	ID         int           `json:"id"`
+	Repository string        `json:"repository"`
+	Branch     string        `json:"branch"`
+	Puppetfile string        `json:"puppetfile"`
+	ModulePath string        `json:"module_path"`
+	State      string        `json:"state"`
+	Queued     time.Time     `json:"queued"`
+	Started    *time.Time    `json:"started,omitempty"`
+	Finished   *time.Time    `json:"finished,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Modules    []reportEntry `json:"modules,omitempty"`
+
+	route ServerRoute
+}
+
+// server queues installs for webhooks. Installs in a module path run
+// one by one, and a push to a queued one doesn't queue it again.
+type server struct {
+	Installer
+	secret string
+	routes []ServerRoute
+	keep   []string      // globs of directory names never purged
+	wait   time.Duration // for the lock of a module path
+
+	ctx     context.Context
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	nextID  int
+	deploys []*deployStatus            // recent ones, newest last
+	queues  map[string][]*deployStatus // waiting ones for each module path
+	running map[string]bool            // module paths which have a worker
+}
+
+func newServer(ctx context.Context, c Installer, cfg ServerConfig, keep []string, wait time.Duration) (*server, error) {
+	if cfg.Secret == "" {
+		return nil, fmt.Errorf("secret is required for webhooks")
+	}
+	if len(cfg.Routes) == 0 {
+		return nil, fmt.Errorf("no route is given in the config")
+	}
+	for i, r := range cfg.Routes {
+		if r.Puppetfile == "" {
+			return nil, fmt.Errorf("puppetfile is missing in route %v", r.Repository)
+		}
+		if _, err := path.Match(r.Repository, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern of repository: %v", r.Repository)
+		}
+		if _, err := path.Match(r.Branch, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern of branch: %v", r.Branch)
+		}
+		if r.ModulePath == "" {
+			cfg.Routes[i].ModulePath = "modules"
+		}
+	}
+	c.Logger = orDiscard(c.Logger)
+	return &server{
+		Installer: c,
+		secret:    cfg.Secret,
+		routes:    cfg.Routes,
+		keep:      keep,
+		wait:      wait,
+		ctx:       ctx,
+		queues:    map[string][]*deployStatus{},
+		running:   map[string]bool{},
+	}, nil
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook", s.webhook)
+	mux.HandleFunc("/status", s.status)
+	if s.Metrics != nil {
+		mux.Handle("/metrics", s.Metrics)
+	}
+	return mux
+}
+
+// webhook queues an install for each route matching a push.
+func (s *server) webhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e, err := parseWebhook(r.Header, body, s.secret)
+	switch {
+	case err == errSignature:
+		log.Printf("[server] %v from %v", err, r.RemoteAddr)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case err == errNotPush:
+		writeJSON(w, http.StatusOK, map[string][]int{"ids": {}})
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ids := []int{}
+	for _, b := range e.Branches {
+		for _, rt := range s.routes {
+			if rt.match(e.Repository, b) {
+				ids = append(ids, s.enqueue(rt, e.Repository, b))
+			}
+		}
+	}
+	s.Logger.Printf("[server] push to %v %v: %v", e.Repository, e.Branches, ids)
+	code := http.StatusOK
+	if len(ids) > 0 {
+		code = http.StatusAccepted
+	}
+	writeJSON(w, code, map[string][]int{"ids": ids})
+}
+
+func (rt ServerRoute) match(repo, branch string) bool {
+	if ok, _ := path.Match(rt.Repository, repo); !ok {
+		return false
+	}
+	ok, _ := path.Match(rt.Branch, branch)
+	return rt.Branch == "" || ok
+}
+
+// status shows recent deploys, newest first.
+func (s *server) status(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	ds := make([]deployStatus, len(s.deploys))
+	for i, d := range s.deploys {
+		ds[len(ds)-1-i] = *d
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string][]deployStatus{"deploys": ds})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// enqueue queues an install of a route and returns its id, or id of
+// the same one which is still waiting.
+func (s *server) enqueue(rt ServerRoute, repo, branch string) int {
+	key := filepath.Clean(rt.ModulePath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.queues[key] {
+		if d.route == rt {
+			return d.ID
+		}
+	}
+	s.nextID++
+	d := &deployStatus{
+		ID:         s.nextID,
+		Repository: repo,
+		Branch:     branch,
+		Puppetfile: rt.Puppetfile,
+		ModulePath: rt.ModulePath,
+		State:      deployQueued,
+		Queued:     time.Now(),
+		route:      rt,
+	}
+	s.queues[key] = append(s.queues[key], d)
+	s.deploys = append(s.deploys, d)
+	if len(s.deploys) > deploysKept {
+		s.deploys = s.deploys[len(s.deploys)-deploysKept:]
+	}
+	if !s.running[key] {
+		s.running[key] = true
+		s.wg.Add(1)
+		go s.work(key)
+	}
+	return d.ID
+}
+
+// work runs installs queued for a module path until the queue is empty.
+func (s *server) work(key string) {
+	defer s.wg.Done()
+	for {
+		s.mu.Lock()
+		q := s.queues[key]
+		if len(q) == 0 {
+			delete(s.queues, key)
+			delete(s.running, key)
+			s.mu.Unlock()
+			return
+		}
+		d := q[0]
+		s.queues[key] = q[1:]
+		started := time.Now()
+		d.State, d.Started = deployRunning, &started
+		s.mu.Unlock()
+
+		results, err := s.install(d.route)
+
+		s.mu.Lock()
+		finished := time.Now()
+		d.Finished = &finished
+		d.State = deploySucceeded
+		for _, r := range results {
+			d.Modules = append(d.Modules, newReportEntry(r))
+		}
+		if err != nil {
+			d.State, d.Error = deployFailed, err.Error()
+		} else if len(failedResults(results)) > 0 {
+			d.State = deployFailed
+		}
+		s.mu.Unlock()
+		log.Printf("[server] deploy %v: %v %v into %v", d.ID, d.State, d.Puppetfile, d.ModulePath)
+	}
+}
+
+// install installs mods in the Puppetfile of a route holding locks of directories they're installed into.
+func (s *server) install(rt ServerRoute) ([]Result, error) {
+	f, err := os.Open(rt.Puppetfile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pf, err := ParsePuppetfile(f, ParseOptions{Dir: filepath.Dir(rt.Puppetfile), Logger: s.Logger})
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := lockDirs(s.ctx, modDirs(rt.ModulePath, pf.Mods, nil), s.wait)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	c := s.Installer
+	c.ModulePath = rt.ModulePath
+	results, err := c.Install(s.ctx, pf.Mods)
+	if err != nil {
+		return nil, err
+	}
+	if rt.Purge && s.ctx.Err() == nil {
+		return results, purge(rt.ModulePath, pf.Mods, purgeOpts{keep: s.keep})
+	}
+	return results, nil
+}
+
+// serve serves webhooks on addr until ctx is done, and waits for running installs.
+func (s *server) serve(addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s.handler()}
+	go func() {
+		<-s.ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	log.Printf("[server] listening on %v", addr)
+	err := srv.ListenAndServe()
+	s.wg.Wait()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
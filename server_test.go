@@ -0,0 +1,160 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewServer(t *testing.T) {
+	ctx := context.Background()
+	rt := ServerRoute{Repository: "a/b", Puppetfile: "Puppetfile"}
+	_, err := newServer(ctx, Installer{}, ServerConfig{Routes: []ServerRoute{rt}}, nil, 0)
+	assert.NotNil(t, err)
+	_, err = newServer(ctx, Installer{}, ServerConfig{Secret: "s"}, nil, 0)
+	assert.NotNil(t, err)
+	_, err = newServer(ctx, Installer{}, ServerConfig{Secret: "s", Routes: []ServerRoute{{Repository: "["}}}, nil, 0)
+	assert.NotNil(t, err)
+	s, err := newServer(ctx, Installer{}, ServerConfig{Secret: "s", Routes: []ServerRoute{rt}}, nil, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "modules", s.routes[0].ModulePath)
+}
+
+func TestServerRouteMatch(t *testing.T) {
+	rt := ServerRoute{Repository: "tmtk75/*", Branch: "release/*"}
+	assert.True(t, rt.match("tmtk75/foo", "release/0.1"))
+	assert.False(t, rt.match("tmtk75/foo", "master"))
+	assert.False(t, rt.match("other/foo", "release/0.1"))
+	rt.Branch = ""
+	assert.True(t, rt.match("tmtk75/foo", "master"))
+}
+
+func TestServer(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	up := filepath.Join(dir, "upstream")
+	pf := filepath.Join(dir, "Puppetfile")
+	ioutil.WriteFile(pf, []byte("mod 'foo', :git => '"+up+"', :ref => 'develop'\n"), 0644)
+	mdir := filepath.Join(dir, "modules")
+	// site is kept by purge_keep while stale is purged
+	os.MkdirAll(filepath.Join(mdir, "site"), 0755)
+	os.MkdirAll(filepath.Join(mdir, "stale"), 0755)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := newServer(ctx, Installer{Metrics: NewMetrics()}, ServerConfig{Secret: "s", Routes: []ServerRoute{
+		{Repository: "tmtk75/*", Branch: "develop", Puppetfile: pf, ModulePath: mdir, Purge: true},
+	}}, []string{"si*"}, time.Minute)
+	assert.Nil(t, err)
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	post := func(body, secret string) (int, map[string][]int) {
+		req, _ := http.NewRequest("POST", ts.URL+"/webhook", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", sign(body, secret))
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		var v map[string][]int
+		json.NewDecoder(res.Body).Decode(&v)
+		return res.StatusCode, v
+	}
+	push := `{"ref": "refs/heads/develop", "repository": {"full_name": "tmtk75/foo"}}`
+	code, _ := post(push, "wrong")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, v := post(`{"ref": "refs/heads/master", "repository": {"full_name": "tmtk75/foo"}}`, "s")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, v["ids"])
+	code, v = post(push, "s")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, []int{1}, v["ids"])
+
+	var st struct {
+		Deploys []struct {
+			ID      int           `json:"id"`
+			State   string        `json:"state"`
+			Modules []reportEntry `json:"modules"`
+		} `json:"deploys"`
+	}
+	for i := 0; i < 100; i++ {
+		res, err := http.Get(ts.URL + "/status")
+		assert.Nil(t, err)
+		json.NewDecoder(res.Body).Decode(&st)
+		res.Body.Close()
+		if len(st.Deploys) > 0 && st.Deploys[0].State != deployQueued && st.Deploys[0].State != deployRunning {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if assert.Equal(t, 1, len(st.Deploys)) {
+		assert.Equal(t, deploySucceeded, st.Deploys[0].State)
+		assert.Equal(t, 1, len(st.Deploys[0].Modules))
+		assert.Equal(t, gitSha1(up, "develop"), st.Deploys[0].Modules[0].Sha1)
+	}
+	assert.Equal(t, gitSha1(up, "develop"), gitHead(filepath.Join(mdir, "foo")))
+	_, err = os.Stat(filepath.Join(mdir, "site"))
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(mdir, "stale"))
+	assert.True(t, os.IsNotExist(err))
+
+	res, err := http.Get(ts.URL + "/metrics")
+	assert.Nil(t, err)
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Contains(t, string(b), `librarian_puppet_modules_total{module_path="`+mdir+`",action="cloned"} 1`)
+	cancel()
+	s.wg.Wait()
+}
+
+func TestServerEnqueue(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lpg-test")
+	defer os.RemoveAll(dir)
+	mdir := filepath.Join(dir, "modules")
+	ctx := context.Background()
+	// the worker waits for the lock while pushes are queued
+	l, err := lockDir(ctx, mdir, 0)
+	assert.Nil(t, err)
+
+	rt := ServerRoute{Repository: "a/*", Puppetfile: filepath.Join(dir, "Puppetfile"), ModulePath: mdir}
+	other := rt
+	other.Puppetfile = filepath.Join(dir, "Puppetfile.other")
+	for _, p := range []string{rt.Puppetfile, other.Puppetfile} {
+		ioutil.WriteFile(p, []byte("mod 'foo', :git => '"+filepath.Join(dir, "none")+"'\n"), 0644)
+	}
+	s, err := newServer(ctx, Installer{}, ServerConfig{Secret: "s", Routes: []ServerRoute{rt, other}}, nil, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, s.enqueue(rt, "a/b", "master"))
+	for i := 0; i < 100; i++ {
+		s.mu.Lock()
+		n := len(s.queues[mdir])
+		s.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 2, s.enqueue(rt, "a/b", "master"))
+	assert.Equal(t, 2, s.enqueue(rt, "a/c", "master"))
+	assert.Equal(t, 3, s.enqueue(other, "a/b", "master"))
+	s.mu.Lock()
+	assert.Equal(t, deployRunning, s.deploys[0].State)
+	assert.Equal(t, 2, len(s.queues[mdir]))
+	s.mu.Unlock()
+
+	l.unlock()
+	s.wg.Wait()
+	for _, d := range s.deploys {
+		assert.Equal(t, deployFailed, d.State) // no repository
+		assert.True(t, d.Finished.After(*d.Started))
+	}
+}
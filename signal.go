@@ -0,0 +1,38 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// withSignals returns a context canceled by SIGINT or SIGTERM
+// so that running git commands end and partial clones are removed.
+// A second signal exits immediately.
+func withSignals(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case s := <-ch:
+			log.Printf("[cancel] %v received. waiting for running git commands. send it again to exit now", s)
+			cancel()
+		case <-stop:
+			return
+		}
+		select {
+		case s := <-ch:
+			log.Fatalf("[fatal] %v received again", s)
+		case <-stop:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(ch)
+		close(stop)
+		cancel()
+	}
+}
@@ -0,0 +1,172 @@
+package librarianpuppetgo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const slowestModules = 5
+
+// stats collects git commands run while installing.
+type stats struct {
+	mu    sync.Mutex
+	start time.Time
+	wall  time.Duration
+	invs  []Invocation
+}
+
+func newStats() *stats {
+	return &stats{start: time.Now()}
+}
+
+// Add is given to Installer.OnGit.
+func (s *stats) Add(i Invocation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.invs = append(s.invs, i)
+}
+
+func (s *stats) finish() {
+	s.wall = time.Since(s.start)
+}
+
+// printSummary prints wall time, time of each git operation, slowest
+// modules, bytes received and utilization of workers.
+func (s *stats) printSummary(w io.Writer, workers int, results []Result) {
+	if workers < 1 || len(results) < workers {
+		workers = len(results)
+	}
+	var busy time.Duration
+	for _, r := range results {
+		busy += r.Duration
+	}
+	util := 0.0
+	if workers > 0 && s.wall > 0 {
+		util = float64(busy) / float64(time.Duration(workers)*s.wall) * 100
+	}
+	fmt.Fprintf(w, "wall time: %v, modules: %v, workers: %v, utilization: %.0f%%\n", s.wall.Round(time.Millisecond), len(results), workers, util)
+
+	type phase struct {
+		op    string
+		count int
+		total time.Duration
+		bytes int64
+	}
+	ps := map[string]*phase{}
+	var bytes int64
+	for _, i := range s.invs {
+		p, ok := ps[i.Op]
+		if !ok {
+			p = &phase{op: i.Op}
+			ps[i.Op] = p
+		}
+		p.count++
+		p.total += i.Duration
+		p.bytes += i.Bytes
+		bytes += i.Bytes
+	}
+	phases := make([]*phase, 0, len(ps))
+	for _, p := range ps {
+		phases = append(phases, p)
+	}
+	sort.Slice(phases, func(i, j int) bool {
+		if phases[i].total == phases[j].total {
+			return phases[i].op < phases[j].op
+		}
+		return phases[i].total > phases[j].total
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "phase\tcount\ttotal\taverage\treceived\n")
+	for _, p := range phases {
+		b := "-"
+		if p.bytes > 0 {
+			b = formatBytes(p.bytes)
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n", p.op, p.count, p.total.Round(time.Millisecond), (p.total / time.Duration(p.count)).Round(time.Millisecond), b)
+	}
+	tw.Flush()
+
+	rs := append([]Result{}, results...)
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].Duration > rs[j].Duration })
+	if len(rs) > slowestModules {
+		rs = rs[:slowestModules]
+	}
+	fmt.Fprintf(w, "slowest modules:\n")
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, r := range rs {
+		fmt.Fprintf(tw, "  %v\t%v\t%v\n", r.Mod.name, r.Duration.Round(time.Millisecond), r.Action)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "received: %v\n", formatBytes(bytes))
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%v bytes", n)
+}
+
+// traceEvent is an event of Chrome trace-event format.
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   int64                  `json:"ts"` // in microseconds
+	Dur  int64                  `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// writeTrace writes a span for each module and each git command in it
+// on a thread of the module.
+func (s *stats) writeTrace(path string, results []Result) error {
+	us := func(t time.Time) int64 { return t.Sub(s.start).Nanoseconds() / 1000 }
+	tids := map[string]int{}
+	es := make([]traceEvent, 0)
+	for i, r := range results {
+		tids[r.Mod.name] = i + 1
+		es = append(es, traceEvent{Name: "thread_name", Ph: "M", Pid: 1, Tid: i + 1, Args: map[string]interface{}{"name": r.Mod.name}})
+	}
+	for _, r := range results {
+		if r.Duration == 0 {
+			continue
+		}
+		args := map[string]interface{}{"action": r.Action, "old": r.OldSha1, "new": r.NewSha1}
+		if r.Err != nil {
+			args["error"] = r.Err.Error()
+		}
+		es = append(es, traceEvent{Name: r.Mod.name, Cat: "module", Ph: "X", Ts: us(r.Start), Dur: r.Duration.Nanoseconds() / 1000, Pid: 1, Tid: tids[r.Mod.name], Args: args})
+	}
+	for _, i := range s.invs {
+		args := map[string]interface{}{"args": i.Args}
+		if i.Bytes > 0 {
+			args["bytes"] = i.Bytes
+		}
+		if i.Err != nil {
+			args["error"] = i.Err.Error()
+		}
+		es = append(es, traceEvent{Name: i.Op, Cat: "git", Ph: "X", Ts: us(i.Start), Dur: i.Duration.Nanoseconds() / 1000, Pid: 1, Tid: tids[i.Mod], Args: args})
+	}
+	b, err := json.Marshal(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{es, "ms"})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,80 @@
+package librarianpuppetgo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceivedBytes(t *testing.T) {
+	assert.Equal(t, int64(0), receivedBytes(""))
+	assert.Equal(t, int64(0), receivedBytes("Receiving objects: 100% (3/3), done."))
+	assert.Equal(t, int64(1136), receivedBytes("Receiving objects:  50% (2/3)\rReceiving objects: 100% (3/3), 1.11 KiB | 1.11 MiB/s, done."))
+	assert.Equal(t, int64(3<<20), receivedBytes("Receiving objects: 100% (900/900), 3.00 MiB | 2.00 MiB/s, done."))
+}
+
+func TestRunnerNet(t *testing.T) {
+	assert.Equal(t, []string{"fetch", "-p"}, runner{}.net("fetch", "-p"))
+	r := runner{observe: func(Invocation) {}}
+	assert.Equal(t, []string{"fetch", "--progress", "-p"}, r.net("fetch", "-p"))
+}
+
+func testStats() (*stats, []Result) {
+	s := newStats()
+	t0 := s.start
+	s.invs = []Invocation{
+		{Mod: "foo", Op: "clone", Start: t0, Duration: 3 * time.Second, Bytes: 2 << 20},
+		{Mod: "foo", Op: "checkout", Start: t0.Add(3 * time.Second), Duration: time.Second},
+		{Mod: "bar", Op: "fetch", Start: t0, Duration: time.Second, Err: errors.New("exit status 128")},
+	}
+	s.wall = 4 * time.Second
+	return s, []Result{
+		{Mod: Mod{name: "foo"}, Action: ActionCloned, Start: t0, Duration: 4 * time.Second},
+		{Mod: Mod{name: "bar"}, Action: ActionFailed, Start: t0, Duration: time.Second, Err: errors.New("fetch failed")},
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	s, results := testStats()
+	buf := bytes.NewBuffer([]byte{})
+	s.printSummary(buf, 0, results)
+	out := buf.String()
+	assert.Contains(t, out, "wall time: 4s, modules: 2, workers: 2, utilization: 62%\n")
+	lines := strings.Split(out, "\n")
+	assert.Equal(t, []string{"clone", "1", "3s", "3s", "2.00", "MiB"}, strings.Fields(lines[2]))
+	assert.Equal(t, []string{"fetch", "1", "1s", "1s", "-"}, strings.Fields(lines[4]))
+	assert.Contains(t, out, "slowest modules:\n  foo  4s  cloned\n  bar  1s  failed\n")
+	assert.Contains(t, out, "received: 2.00 MiB\n")
+}
+
+func TestWriteTrace(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lpg-test")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trace.json")
+	s, results := testStats()
+	assert.Nil(t, s.writeTrace(path, results))
+
+	var v struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	b, _ := ioutil.ReadFile(path)
+	assert.Nil(t, json.Unmarshal(b, &v))
+	assert.Equal(t, 7, len(v.TraceEvents))
+	assert.Equal(t, "thread_name", v.TraceEvents[0].Name)
+	clone := v.TraceEvents[4]
+	assert.Equal(t, "clone", clone.Name)
+	assert.Equal(t, "X", clone.Ph)
+	assert.Equal(t, int64(3000000), clone.Dur)
+	assert.Equal(t, 1, clone.Tid)
+	fetch := v.TraceEvents[6]
+	assert.Equal(t, 2, fetch.Tid)
+	assert.Equal(t, "exit status 128", fetch.Args["error"])
+}
@@ -0,0 +1,36 @@
+package librarianpuppetgo
+
+import (
+	"fmt"
+	"os"
+)
+
+// Strategies to update a module checked out at a branch.
+const (
+	branchPull  = "pull"  // git pull origin <branch>
+	branchReset = "reset" // git reset --hard origin/<branch>
+)
+
+// branchStrategy returns a strategy for a given name. If no name is given,
+// reset is used for non-interactive runs and pull for ones in a terminal.
+func branchStrategy(s string, interactive bool) (string, error) {
+	switch s {
+	case branchPull, branchReset:
+		return s, nil
+	case "":
+		if interactive {
+			return branchPull, nil
+		}
+		return branchReset, nil
+	}
+	return "", fmt.Errorf("unknown branch strategy: %v", s)
+}
+
+// isTerminal reports whether f is a character device like a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
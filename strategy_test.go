@@ -0,0 +1,51 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBranchStrategy(t *testing.T) {
+	s, err := branchStrategy("", true)
+	assert.Nil(t, err)
+	assert.Equal(t, branchPull, s)
+
+	s, err = branchStrategy("", false)
+	assert.Nil(t, err)
+	assert.Equal(t, branchReset, s)
+
+	s, err = branchStrategy(branchPull, false)
+	assert.Nil(t, err)
+	assert.Equal(t, branchPull, s)
+
+	_, err = branchStrategy("rebase", false)
+	assert.NotNil(t, err)
+}
+
+func TestInstallModReset(t *testing.T) {
+	dir := newTestRepo(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	mdir := dir
+
+	up := filepath.Join(dir, "upstream")
+	c := testInstaller(t, Installer{BranchStrategy: branchReset})
+	m := &Mod{name: "foo", moddir: mdir, opts: ModOpts{"git": up, "ref": "develop"}}
+	assert.Nil(t, c.installMod(ctx, m))
+	assert.Equal(t, gitSha1(up, "develop"), gitSha1(m.Dest(), "HEAD"))
+
+	// force-pushed
+	cmd := exec.Command("sh", "-c", "git checkout -q develop && git reset -q --hard HEAD~1 && echo e >> README && git commit -qam e && git checkout -q master")
+	cmd.Dir = up
+	assert.Nil(t, cmd.Run())
+
+	m = &Mod{name: "foo", moddir: mdir, opts: ModOpts{"git": up, "ref": "develop"}}
+	assert.Nil(t, c.installMod(ctx, m))
+	assert.Equal(t, "reset", m.cmd)
+	assert.Equal(t, gitSha1(up, "develop"), gitSha1(m.Dest(), "HEAD"))
+}
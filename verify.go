@@ -0,0 +1,86 @@
+package librarianpuppetgo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Verify checks that modules in a puppetfile are checked out as declared
+// and exits with 1 if something is wrong.
+func Verify(path string, submodules bool) {
+	failed := 0
+	for _, m := range parse(path) {
+		for _, p := range verifyMod(m, submodules) {
+			fmt.Printf("%v\t%v\t%v\n", m.name, m.Dest(), p)
+			failed++
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func verifyMod(m Mod, submodules bool) []string {
+	if !exists(m.Dest()) {
+		return []string{"not installed"}
+	}
+
+	problems := make([]string, 0)
+	ref := m.Ref()
+	if ref == "" {
+		ref = "master"
+	}
+	want := ref
+	branch, err := isBranch(m.Dest(), ref)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if branch {
+		want = "origin/" + ref
+	}
+	head := gitSha1(m.Dest(), "HEAD")
+	if sha1 := gitSha1(m.Dest(), want); sha1 == "" || sha1 != head {
+		problems = append(problems, fmt.Sprintf("HEAD %v is not at %v", head, want))
+	}
+
+	if submodules || m.Submodules() {
+		out, err := gitSubmoduleStatus(m.Dest())
+		if err != nil {
+			return append(problems, fmt.Sprintf("submodule status failed: %v", err))
+		}
+		problems = append(problems, submoduleProblems(out)...)
+	}
+	return problems
+}
+
+// submoduleProblems reads output of `git submodule status` and returns
+// submodules which are out of sync.
+//
+//	-<sha1> <path>          not initialized
+//	+<sha1> <path> (<desc>) checked out commit differs from the recorded one
+//	U<sha1> <path>          merge conflicts
+func submoduleProblems(status string) []string {
+	problems := make([]string, 0)
+	sc := bufio.NewScanner(strings.NewReader(status))
+	for sc.Scan() {
+		l := sc.Text()
+		if len(l) == 0 {
+			continue
+		}
+		f := strings.Fields(l[1:])
+		if len(f) < 2 {
+			continue
+		}
+		switch l[0] {
+		case '-':
+			problems = append(problems, fmt.Sprintf("submodule %v is not initialized", f[1]))
+		case '+':
+			problems = append(problems, fmt.Sprintf("submodule %v is out of sync at %v", f[1], f[0]))
+		case 'U':
+			problems = append(problems, fmt.Sprintf("submodule %v has merge conflicts", f[1]))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,22 @@
+package librarianpuppetgo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubmoduleProblems(t *testing.T) {
+	out := ` 1111111111111111111111111111111111111111 vendor/ok (v1.0.0)
+-2222222222222222222222222222222222222222 vendor/new
++3333333333333333333333333333333333333333 vendor/moved (v1.0.1-2-g3333333)
+U4444444444444444444444444444444444444444 vendor/conflict
+`
+	assert.Equal(t, []string{
+		"submodule vendor/new is not initialized",
+		"submodule vendor/moved is out of sync at 3333333333333333333333333333333333333333",
+		"submodule vendor/conflict has merge conflicts",
+	}, submoduleProblems(out))
+
+	assert.Equal(t, []string{}, submoduleProblems(""))
+}
@@ -0,0 +1,171 @@
+package librarianpuppetgo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Polling of puppetfiles for --watch. Changes are handled after files
+// stay the same for watchDebounce so that saving several files runs once.
+const (
+	watchInterval = 500 * time.Millisecond
+	watchDebounce = time.Second
+)
+
+// watchFile installs modules in a puppetfile, and then installs mods whose
+// declarations are changed each time it or its includes are modified
+// until ctx is done. Results are printed for each run.
+func (c installCmd) watchFile(ctx context.Context, path string) ([]Result, error) {
+	old, files, err := parseFiles(path, c.modulePath())
+	if err != nil {
+		return nil, err
+	}
+	if c.metricsAddr != "" && c.Metrics != nil {
+		go func() {
+			if err := serveMetrics(ctx, c.metricsAddr, c.Metrics); err != nil {
+				log.Printf("[watch] metrics: %v", err)
+			}
+		}()
+	}
+	c.runWatched(ctx, old, old)
+	snap := snapshot(files)
+	for {
+		log.Printf("[watch] waiting for changes of %v", files)
+		var ok bool
+		if snap, ok = waitChange(ctx, files, snap, watchInterval, watchDebounce); !ok {
+			return nil, nil
+		}
+		mods, fs, err := parseFiles(path, c.modulePath())
+		if err != nil {
+			log.Printf("[watch] %v", err)
+			continue
+		}
+		files, snap = fs, snapshot(fs)
+		changed := diffMods(os.Stdout, old, mods)
+		old = mods
+		if len(changed) == 0 && c.purge == nil {
+			continue
+		}
+		c.runWatched(ctx, mods, changed)
+	}
+}
+
+// runWatched installs ms holding the lock, and prints results.
+func (c installCmd) runWatched(ctx context.Context, all, ms []Mod) {
+	unlock, err := c.lock(ctx, all)
+	if err != nil {
+		log.Printf("[watch] %v", err)
+		return
+	}
+	defer unlock()
+	defer c.writeMetrics()
+	results, err := c.installMods(ctx, all, ms)
+	printResults(results)
+	if err != nil {
+		log.Printf("[watch] %v", err)
+	}
+}
+
+// parseFiles parses a puppetfile, and returns mods in moddir and files
+// including itself. Unlike commands, a missing file is an error instead of exit.
+func parseFiles(path, moddir string) ([]Mod, []string, error) {
+	files := []string{}
+	p := parser{
+		open: func(n string) (io.ReadCloser, error) {
+			files = append(files, n)
+			return os.Open(n)
+		},
+		logger: logger,
+	}
+	f, err := p.open(path)
+	if err != nil {
+		return nil, files, err
+	}
+	defer f.Close()
+	mods, err := p.parse(f)
+	for i := range mods {
+		mods[i].moddir = moddir
+	}
+	return mods, files, err
+}
+
+// snapshot returns modification time and size of each file, or empty if missing.
+func snapshot(files []string) map[string]string {
+	s := make(map[string]string, len(files))
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			s[f] = fmt.Sprintf("%v %v", fi.ModTime().UnixNano(), fi.Size())
+		} else {
+			s[f] = ""
+		}
+	}
+	return s
+}
+
+// waitChange polls files until they're different from snap and then stay
+// the same for debounce. It returns the last snapshot, or false if ctx is done.
+func waitChange(ctx context.Context, files []string, snap map[string]string, interval, debounce time.Duration) (map[string]string, bool) {
+	var changed time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return snap, false
+		case <-time.After(interval):
+		}
+		s := snapshot(files)
+		if !sameSnapshot(s, snap) {
+			snap, changed = s, time.Now()
+			continue
+		}
+		if !changed.IsZero() && time.Since(changed) >= debounce {
+			return snap, true
+		}
+	}
+}
+
+func sameSnapshot(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// diffMods prints declarations removed with "-" and added with "+",
+// and returns mods which are added or changed in news.
+func diffMods(w io.Writer, olds, news []Mod) []Mod {
+	decl := func(m Mod) string {
+		return m.Format() + "\t# " + m.Dest()
+	}
+	byName := map[string]Mod{}
+	for _, m := range olds {
+		byName[m.name] = m
+	}
+	changed := make([]Mod, 0)
+	for _, m := range news {
+		o, ok := byName[m.name]
+		delete(byName, m.name)
+		if ok && decl(o) == decl(m) {
+			continue
+		}
+		if ok {
+			fmt.Fprintf(w, "- %v\n", decl(o))
+		}
+		fmt.Fprintf(w, "+ %v\n", decl(m))
+		changed = append(changed, m)
+	}
+	for _, m := range olds {
+		if _, ok := byName[m.name]; ok {
+			fmt.Fprintf(w, "- %v\n", decl(m))
+		}
+	}
+	return changed
+}
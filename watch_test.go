@@ -0,0 +1,86 @@
+package librarianpuppetgo
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffMods(t *testing.T) {
+	olds, _ := parsePuppetfile(r(`
+mod 'foo', :git => 'a', :ref => 'v0.1.0'
+mod 'bar', :git => 'b', :ref => 'master'
+mod 'old', :git => 'c', :ref => 'master'
+`))
+	news, _ := parsePuppetfile(r(`
+mod 'foo', :git => 'a', :ref => 'v0.2.0'
+mod 'bar', :git => 'b', :ref => 'master'
+mod 'baz', :git => 'd', :ref => 'master'
+`))
+	buf := bytes.NewBuffer([]byte{})
+	changed := diffMods(buf, olds, news)
+	assert.Equal(t, 2, len(changed))
+	assert.Equal(t, "foo", changed[0].Name())
+	assert.Equal(t, "baz", changed[1].Name())
+	assert.Equal(t, `- mod 'foo', :git => 'a', :ref => 'v0.1.0'	# modules/foo
++ mod 'foo', :git => 'a', :ref => 'v0.2.0'	# modules/foo
++ mod 'baz', :git => 'd', :ref => 'master'	# modules/baz
+- mod 'old', :git => 'c', :ref => 'master'	# modules/old
+`, buf.String())
+
+	buf.Reset()
+	assert.Empty(t, diffMods(buf, news, news))
+	assert.Empty(t, buf.String())
+}
+
+func TestParseFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lpg-test")
+	defer os.RemoveAll(dir)
+	common := filepath.Join(dir, "Puppetfile.common")
+	pf := filepath.Join(dir, "Puppetfile")
+	ioutil.WriteFile(common, []byte("mod 'foo', :git => 'a'\n"), 0644)
+	ioutil.WriteFile(pf, []byte("include '"+common+"'\nmod 'bar', :git => 'b'\n"), 0644)
+
+	mods, files, err := parseFiles(pf, "vendor")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{pf, common}, files)
+	assert.Equal(t, 2, len(mods))
+	assert.Equal(t, filepath.Join("vendor", "foo"), mods[0].Dest())
+
+	os.Remove(common)
+	_, files, err = parseFiles(pf, "vendor")
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{pf, common}, files)
+}
+
+func TestWaitChange(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "lpg-test")
+	defer os.RemoveAll(dir)
+	f := filepath.Join(dir, "Puppetfile")
+	ioutil.WriteFile(f, []byte("a\n"), 0644)
+	files := []string{f}
+	snap := snapshot(files)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, ok := waitChange(ctx, files, snap, 10*time.Millisecond, 30*time.Millisecond)
+	assert.False(t, ok)
+
+	go func() {
+		for _, s := range []string{"ab\n", "abc\n"} {
+			time.Sleep(20 * time.Millisecond)
+			ioutil.WriteFile(f, []byte(s), 0644)
+		}
+	}()
+	start := time.Now()
+	s, ok := waitChange(context.Background(), files, snap, 10*time.Millisecond, 100*time.Millisecond)
+	assert.True(t, ok)
+	assert.Equal(t, snapshot(files), s)
+	assert.True(t, time.Since(start) >= 140*time.Millisecond)
+}
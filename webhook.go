@@ -0,0 +1,147 @@
+package librarianpuppetgo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// pushEvent is a push parsed from a webhook.
+type pushEvent struct {
+	Repository string   // full name such as tmtk75/control
+	Branches   []string // pushed branches, deleted ones are excluded
+}
+
+var (
+	errSignature = errors.New("signature mismatch")
+	errNotPush   = errors.New("not a push event")
+)
+
+// parseWebhook verifies a webhook of GitHub, GitLab or Bitbucket with
+// secret and parses a push. GitHub and Bitbucket sign the body with
+// HMAC-SHA256, and GitLab sends the secret as a token.
+func parseWebhook(h http.Header, body []byte, secret string) (pushEvent, error) {
+	var e pushEvent
+	var p webhookPayload
+	switch {
+	case h.Get("X-GitHub-Event") != "":
+		if !validSignature(body, secret, h.Get("X-Hub-Signature-256")) {
+			return e, errSignature
+		}
+		if h.Get("X-GitHub-Event") != "push" {
+			return e, errNotPush
+		}
+		if err := json.Unmarshal(body, &p); err != nil {
+			return e, err
+		}
+		e.Repository = p.Repository.FullName
+		if !p.Deleted {
+			e.Branches = branchOf(p.Ref)
+		}
+	case h.Get("X-Gitlab-Event") != "":
+		if !hmac.Equal([]byte(h.Get("X-Gitlab-Token")), []byte(secret)) {
+			return e, errSignature
+		}
+		if h.Get("X-Gitlab-Event") != "Push Hook" {
+			return e, errNotPush
+		}
+		if err := json.Unmarshal(body, &p); err != nil {
+			return e, err
+		}
+		e.Repository = p.Project.PathWithNamespace
+		if strings.Trim(p.After, "0") != "" {
+			e.Branches = branchOf(p.Ref)
+		}
+	case h.Get("X-Event-Key") != "":
+		if !validSignature(body, secret, h.Get("X-Hub-Signature")) {
+			return e, errSignature
+		}
+		k := h.Get("X-Event-Key")
+		if k != "repo:push" && k != "repo:refs_changed" {
+			return e, errNotPush
+		}
+		if err := json.Unmarshal(body, &p); err != nil {
+			return e, err
+		}
+		e.Repository = p.Repository.FullName
+		if e.Repository == "" { // Bitbucket Server
+			e.Repository = p.Repository.Project.Key + "/" + p.Repository.Slug
+		}
+		for _, c := range p.Push.Changes {
+			if c.New != nil && c.New.Type == "branch" {
+				e.Branches = append(e.Branches, c.New.Name)
+			}
+		}
+		for _, c := range p.Changes {
+			if c.Ref.Type == "BRANCH" && c.Type != "DELETE" {
+				e.Branches = append(e.Branches, c.Ref.DisplayID)
+			}
+		}
+	default:
+		return e, fmt.Errorf("unknown webhook")
+	}
+	if e.Repository == "" {
+		return e, fmt.Errorf("repository is missing")
+	}
+	return e, nil
+}
+
+// webhookPayload has fields used in payloads of GitHub, GitLab,
+// Bitbucket Cloud and Bitbucket Server.
+type webhookPayload struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		Slug     string `json:"slug"`
+		Project  struct {
+			Key string `json:"key"`
+		} `json:"project"`
+	} `json:"repository"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+	Push struct {
+		Changes []struct {
+			New *struct {
+				Type string `json:"type"`
+				Name string `json:"name"`
+			} `json:"new"`
+		} `json:"changes"`
+	} `json:"push"`
+	Changes []struct {
+		Ref struct {
+			DisplayID string `json:"displayId"`
+			Type      string `json:"type"`
+		} `json:"ref"`
+		Type string `json:"type"`
+	} `json:"changes"`
+}
+
+// branchOf returns a branch of refs/heads/<branch>, or nothing for a tag.
+func branchOf(ref string) []string {
+	if !strings.HasPrefix(ref, "refs/heads/") {
+		return nil
+	}
+	return []string{strings.TrimPrefix(ref, "refs/heads/")}
+}
+
+// validSignature checks sig given as sha256=<hex> of HMAC-SHA256 of body.
+func validSignature(body []byte, secret, sig string) bool {
+	if !strings.HasPrefix(sig, "sha256=") {
+		return false
+	}
+	b, err := hex.DecodeString(strings.TrimPrefix(sig, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(b, mac.Sum(nil))
+}
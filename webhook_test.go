@@ -0,0 +1,66 @@
+package librarianpuppetgo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sign(body, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseWebhook(t *testing.T) {
+	gh := `{"ref": "refs/heads/master", "repository": {"full_name": "tmtk75/control"}}`
+	h := http.Header{"X-Github-Event": {"push"}, "X-Hub-Signature-256": {sign(gh, "s")}}
+	e, err := parseWebhook(h, []byte(gh), "s")
+	assert.Nil(t, err)
+	assert.Equal(t, pushEvent{Repository: "tmtk75/control", Branches: []string{"master"}}, e)
+
+	_, err = parseWebhook(h, []byte(gh), "other")
+	assert.Equal(t, errSignature, err)
+	h.Del("X-Hub-Signature-256")
+	_, err = parseWebhook(h, []byte(gh), "s")
+	assert.Equal(t, errSignature, err)
+
+	ping := `{"zen": "a"}`
+	_, err = parseWebhook(http.Header{"X-Github-Event": {"ping"}, "X-Hub-Signature-256": {sign(ping, "s")}}, []byte(ping), "s")
+	assert.Equal(t, errNotPush, err)
+
+	tag := `{"ref": "refs/tags/v0.1.0", "repository": {"full_name": "tmtk75/control"}}`
+	e, err = parseWebhook(http.Header{"X-Github-Event": {"push"}, "X-Hub-Signature-256": {sign(tag, "s")}}, []byte(tag), "s")
+	assert.Nil(t, err)
+	assert.Empty(t, e.Branches)
+
+	gl := `{"ref": "refs/heads/develop", "after": "1e56b5f", "project": {"path_with_namespace": "puppet/control"}}`
+	h = http.Header{"X-Gitlab-Event": {"Push Hook"}, "X-Gitlab-Token": {"s"}}
+	e, err = parseWebhook(h, []byte(gl), "s")
+	assert.Nil(t, err)
+	assert.Equal(t, pushEvent{Repository: "puppet/control", Branches: []string{"develop"}}, e)
+	_, err = parseWebhook(h, []byte(gl), "other")
+	assert.Equal(t, errSignature, err)
+
+	deleted := `{"ref": "refs/heads/develop", "after": "0000000000000000000000000000000000000000", "project": {"path_with_namespace": "puppet/control"}}`
+	e, err = parseWebhook(h, []byte(deleted), "s")
+	assert.Nil(t, err)
+	assert.Empty(t, e.Branches)
+
+	bb := `{"repository": {"full_name": "tmtk75/foo"}, "push": {"changes": [{"new": {"type": "branch", "name": "master"}}, {"new": null}, {"new": {"type": "tag", "name": "v1"}}]}}`
+	e, err = parseWebhook(http.Header{"X-Event-Key": {"repo:push"}, "X-Hub-Signature": {sign(bb, "s")}}, []byte(bb), "s")
+	assert.Nil(t, err)
+	assert.Equal(t, pushEvent{Repository: "tmtk75/foo", Branches: []string{"master"}}, e)
+
+	bbs := `{"repository": {"slug": "foo", "project": {"key": "PUP"}}, "changes": [{"ref": {"displayId": "master", "type": "BRANCH"}, "type": "UPDATE"}, {"ref": {"displayId": "old", "type": "BRANCH"}, "type": "DELETE"}]}`
+	e, err = parseWebhook(http.Header{"X-Event-Key": {"repo:refs_changed"}, "X-Hub-Signature": {sign(bbs, "s")}}, []byte(bbs), "s")
+	assert.Nil(t, err)
+	assert.Equal(t, pushEvent{Repository: "PUP/foo", Branches: []string{"master"}}, e)
+
+	_, err = parseWebhook(http.Header{}, []byte(gh), "s")
+	assert.NotNil(t, err)
+}